@@ -0,0 +1,67 @@
+package xmap
+
+import "time"
+
+// AgeOption configures the age used by [Map.DeleteOlderThan].
+type AgeOption func(*ageOptions)
+
+// ageOptions is the configuration of [Map.DeleteOlderThan].
+type ageOptions struct {
+	access bool // Use the last access time instead of the creation time.
+}
+
+// ByLastAccess makes [Map.DeleteOlderThan] compute the age of the keys from their
+// last access time instead of their creation time.
+//
+// The last access time is the last write time of the key, or its last read time
+// if [Config.TrackAccess] is enabled (See [Map.StaleKeys]).
+func ByLastAccess() AgeOption {
+	return func(o *ageOptions) {
+		o.access = true
+	}
+}
+
+// DeleteOlderThan removes the live keys older than age under a single write lock,
+// and returns the number of the removed keys.
+//
+// By default the age of a key is computed from its creation time (See [Map.Age]),
+// so the keys that are kept alive by extending their expiration are removed like
+// with [Config.MaxAge], use [ByLastAccess] to remove the keys that have not been
+// used instead.
+//
+// The expired keys are not counted, they are left to the cleanup.
+func (m *Map[K, V]) DeleteOlderThan(age time.Duration, opts ...AgeOption) int {
+	var o ageOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return 0
+	}
+
+	now := m.time.Now()
+
+	var removed int
+	for key, entry := range m.kv.Range {
+		if entry.err != nil || m.expiredAt(entry, now) {
+			continue
+		}
+
+		since := entry.created
+		if o.access {
+			since = m.lastAccess(entry)
+		}
+
+		if now.Sub(since) > age {
+			m.remove(key)
+			removed++
+		}
+	}
+	m.autoCompact()
+
+	return removed
+}
@@ -0,0 +1,107 @@
+package xmap
+
+// Backend represents the storage of the [Map] records.
+//
+// The key expiration and the synchronization are handled by the [Map], the
+// methods are never called concurrently with Set, Delete or Clear.
+//
+// Only in-process stores are supported: the [Map] changes the records returned by
+// Get and Range in place, so a Backend must keep the record pointers stored using
+// Set and return the same pointers. The records cannot be serialized, a Backend
+// that copies or persists the records (e.g. a remote or an on-disk store) is not
+// supported.
+type Backend[K comparable, V any] interface {
+	// Get returns the record of the key and reports whether the key exists.
+	Get(key K) (*Record[V], bool)
+	// Set creates or replaces the record of the key.
+	Set(key K, record *Record[V])
+	// Delete removes the record of the key.
+	Delete(key K)
+	// Range calls yield for each key and record until yield returns false.
+	// Deleting the current key during the iteration must be supported.
+	Range(yield func(K, *Record[V]) bool)
+	// Len returns the number of records.
+	Len() int
+	// Clear removes all the records.
+	Clear()
+}
+
+// shrinker is implemented by the backends that can release their unused memory.
+type shrinker interface {
+	// shrink releases the unused memory, keeping room for at least capacity records.
+	shrink(capacity int)
+	// peak returns the highest number of records since the last shrink.
+	peak() int
+}
+
+var (
+	_ Backend[string, int] = (*memoryBackend[string, int])(nil)
+	_ shrinker             = (*memoryBackend[string, int])(nil)
+)
+
+// memoryBackend is the default [Backend] that stores the records in a Go map.
+type memoryBackend[K comparable, V any] struct {
+	kv  map[K]*Record[V] // The underlying map.
+	max int              // The highest number of records since the last shrink.
+}
+
+// newMemoryBackend creates a new in-memory [Backend] with an initial capacity hint.
+func newMemoryBackend[K comparable, V any](capacity int) *memoryBackend[K, V] {
+	return &memoryBackend[K, V]{kv: make(map[K]*Record[V], capacity)}
+}
+
+// Get returns the record of the key and reports whether the key exists.
+func (b *memoryBackend[K, V]) Get(key K) (*Record[V], bool) {
+	record, ok := b.kv[key]
+	return record, ok
+}
+
+// Set creates or replaces the record of the key.
+func (b *memoryBackend[K, V]) Set(key K, record *Record[V]) {
+	b.kv[key] = record
+	b.max = max(b.max, len(b.kv))
+}
+
+// Delete removes the record of the key.
+func (b *memoryBackend[K, V]) Delete(key K) {
+	delete(b.kv, key)
+}
+
+// Range calls yield for each key and record until yield returns false.
+func (b *memoryBackend[K, V]) Range(yield func(K, *Record[V]) bool) {
+	for key, record := range b.kv {
+		if !yield(key, record) {
+			return
+		}
+	}
+}
+
+// Len returns the number of records.
+func (b *memoryBackend[K, V]) Len() int {
+	return len(b.kv)
+}
+
+// Clear removes all the records.
+func (b *memoryBackend[K, V]) Clear() {
+	clear(b.kv)
+}
+
+// shrink copies the records into a new map to release the memory of the old one,
+// the new map is created with room for at least capacity records.
+//
+// Go maps do not shrink after deleting keys.
+func (b *memoryBackend[K, V]) shrink(capacity int) {
+	kv := make(map[K]*Record[V], max(len(b.kv), capacity))
+	for key, record := range b.kv {
+		kv[key] = record
+	}
+	b.kv = kv
+	b.max = len(kv)
+}
+
+// peak returns the highest number of records since the last shrink.
+//
+// The memory used by a Go map is proportional to its highest number of keys.
+func (b *memoryBackend[K, V]) peak() int {
+	return b.max
+}
@@ -0,0 +1,90 @@
+package xmap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+var _ xmap.Backend[string, int] = (*testBackend[string, int])(nil)
+
+// testBackend is a [xmap.Backend] that counts the stored records.
+type testBackend[K comparable, V any] struct {
+	records map[K]*xmap.Record[V]
+	sets    int
+}
+
+func (b *testBackend[K, V]) Get(key K) (*xmap.Record[V], bool) {
+	record, ok := b.records[key]
+	return record, ok
+}
+
+func (b *testBackend[K, V]) Set(key K, record *xmap.Record[V]) {
+	b.sets++
+	b.records[key] = record
+}
+
+func (b *testBackend[K, V]) Delete(key K) {
+	delete(b.records, key)
+}
+
+func (b *testBackend[K, V]) Range(yield func(K, *xmap.Record[V]) bool) {
+	for key, record := range b.records {
+		if !yield(key, record) {
+			return
+		}
+	}
+}
+
+func (b *testBackend[K, V]) Len() int {
+	return len(b.records)
+}
+
+func (b *testBackend[K, V]) Clear() {
+	clear(b.records)
+}
+
+func TestMapWithBackend(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	backend := &testBackend[string, int]{records: make(map[string]*xmap.Record[int])}
+
+	m := xmap.NewWithBackend(xmap.Config{TimeSource: testTime}, backend)
+	defer m.Stop()
+
+	m.Set("a", 1, time.Minute)
+	m.Set("b", 2, 0)
+
+	if backend.sets != 2 {
+		t.Errorf("want %d records stored in the backend, got %d", 2, backend.sets)
+	}
+
+	record, ok := backend.records["a"]
+	if !ok {
+		t.Fatalf("key %q was not stored in the backend", "a")
+	}
+
+	if record.Value() != 1 || !record.Expiration().Equal(now.Add(time.Minute)) {
+		t.Errorf("want record value %d with expiration %v, got %d with expiration %v",
+			1, now.Add(time.Minute), record.Value(), record.Expiration())
+	}
+
+	// The expiration is handled by the map.
+	testTime.Advance(2 * time.Minute)
+
+	if _, ok := m.Get("a"); ok {
+		t.Errorf("key %q did not expire", "a")
+	}
+
+	if removed := m.RemoveExpired(); removed != 1 {
+		t.Errorf("want %d key removals, got %d", 1, removed)
+	}
+
+	if len(backend.records) != 1 {
+		t.Errorf("want %d records in the backend, got %d", 1, len(backend.records))
+	}
+}
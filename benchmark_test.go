@@ -1,6 +1,8 @@
 package xmap_test
 
 import (
+	"fmt"
+	"runtime"
 	"testing"
 	"time"
 
@@ -332,3 +334,256 @@ func BenchmarkMapUpdate(b *testing.B) {
 		})
 	})
 }
+
+func BenchmarkShardedRemoveExpired(b *testing.B) {
+	const keys = 100_000
+
+	now := time.Now()
+
+	newMap := func(b *testing.B) (*xmap.Sharded[int, int], *mockTime) {
+		b.Helper()
+
+		testTime := newMockTime(now)
+		m := xmap.NewSharded[int, int](8, xmap.Config{
+			TimeSource:         testTime,
+			DisableAutoCleanup: true,
+		})
+
+		return m, testTime
+	}
+
+	fill := func(m *xmap.Sharded[int, int], testTime *mockTime) {
+		testTime.Set(now)
+		for i := range keys {
+			m.Set(i, i, time.Minute)
+		}
+		testTime.Advance(2 * time.Minute)
+	}
+
+	b.Run("sequential", func(b *testing.B) {
+		m, testTime := newMap(b)
+		defer m.Stop()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			fill(m, testTime)
+			b.StartTimer()
+
+			if removed := m.RemoveExpired(); removed != keys {
+				b.Fatalf("want %d key removals, got %d", keys, removed)
+			}
+		}
+		b.StopTimer()
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		m, testTime := newMap(b)
+		defer m.Stop()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			fill(m, testTime)
+			b.StartTimer()
+
+			if removed := m.RemoveExpiredParallel(8); removed != keys {
+				b.Fatalf("want %d key removals, got %d", keys, removed)
+			}
+		}
+		b.StopTimer()
+	})
+}
+
+func BenchmarkShardedAuto(b *testing.B) {
+	const keys = 10_000
+
+	for _, procs := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("GOMAXPROCS=%d", procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+
+			m := xmap.NewShardedAuto[int, int](xmap.Config{})
+			defer m.Stop()
+
+			for i := range keys {
+				m.Set(i, i, 0)
+			}
+
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					// 1 write for every 4 reads.
+					if i%5 == 0 {
+						m.Set(i%keys, i, 0)
+					} else {
+						m.Get(i % keys)
+					}
+					i++
+				}
+			})
+
+			b.ReportMetric(float64(m.Shards()), "shards")
+		})
+	}
+}
+
+func BenchmarkMapLockModeGet(b *testing.B) {
+	modes := []struct {
+		name string
+		mode xmap.LockMode
+	}{
+		{"write_preferring", xmap.LockWritePreferring},
+		{"read_preferring", xmap.LockReadPreferring},
+	}
+
+	for _, tc := range modes {
+		b.Run(tc.name, func(b *testing.B) {
+			m := xmap.NewWithConfig[string, int](xmap.Config{
+				LockMode: tc.mode,
+			})
+			defer m.Stop()
+
+			m.Set("keyName", 100, time.Hour)
+
+			// Concurrent writer.
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				for {
+					select {
+					case <-done:
+						return
+					default:
+						m.Set("other", 100, time.Hour)
+					}
+				}
+			}()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, ok := m.Get("keyName"); !ok {
+						b.Fatal("key does not exist")
+					}
+				}
+			})
+			b.StopTimer()
+		})
+	}
+}
+
+func BenchmarkMapCompact(b *testing.B) {
+	const (
+		peak = 1_000_000
+		keep = 1_000
+	)
+
+	heap := func() uint64 {
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		return stats.HeapInuse
+	}
+
+	var before, after uint64
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		m := xmap.NewWithConfig[int, int](xmap.Config{
+			DisableAutoCleanup: true,
+		})
+		for k := range peak {
+			m.Set(k, k, 0)
+		}
+		for k := keep; k < peak; k++ {
+			m.Delete(k)
+		}
+		before = heap()
+		b.StartTimer()
+
+		m.Compact()
+
+		b.StopTimer()
+		after = heap()
+		m.Stop()
+		b.StartTimer()
+	}
+
+	b.ReportMetric(float64(before), "heap-before-bytes")
+	b.ReportMetric(float64(after), "heap-after-bytes")
+}
+
+func BenchmarkMapAutoCompact(b *testing.B) {
+	const (
+		peak = 1_000_000
+		keep = 1_000
+	)
+
+	var after uint64
+
+	for i := 0; i < b.N; i++ {
+		m := xmap.NewWithConfig[int, int](xmap.Config{
+			DisableAutoCleanup:   true,
+			AutoCompactThreshold: 0.25,
+		})
+		for k := range peak {
+			m.Set(k, k, 0)
+		}
+		for k := keep; k < peak; k++ {
+			m.Delete(k)
+		}
+
+		b.StopTimer()
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		after = stats.HeapInuse
+		m.Stop()
+		b.StartTimer()
+	}
+
+	b.ReportMetric(float64(after), "heap-after-bytes")
+}
+
+func BenchmarkMapShrinkOnCleanup(b *testing.B) {
+	const (
+		peak = 1_000_000
+		keep = 1_000
+	)
+
+	var after uint64
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		testTime := newMockTime(time.Now())
+		m := xmap.NewWithConfig[int, int](xmap.Config{
+			ShrinkOnCleanup: true,
+			TimeSource:      testTime,
+		})
+		for k := range peak {
+			ttl := time.Minute
+			if k < keep {
+				ttl = 0
+			}
+			m.Set(k, k, ttl)
+		}
+		retryUntil(time.Second, m.CleanupActive)
+		testTime.Advance(time.Hour)
+		b.StartTimer()
+
+		testTime.Tick()
+		retryUntil(10*time.Second, func() bool { return !m.Health().LastCleanup.IsZero() })
+
+		b.StopTimer()
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		after = stats.HeapInuse
+		m.Stop()
+		b.StartTimer()
+	}
+
+	b.ReportMetric(float64(after), "heap-after-bytes")
+}
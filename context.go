@@ -0,0 +1,29 @@
+package xmap
+
+import "context"
+
+// contextKey is the key of a [Map] stored in a [context.Context].
+//
+// It's an empty generic struct, each instantiation is a distinct type so a map is
+// stored under a different key for each key and value types.
+type contextKey[K comparable, V any] struct{}
+
+// NewContext returns a copy of the parent context that carries the [Map] m, it can
+// be retrieved using [FromContext] with the same key and value types.
+//
+// A context can carry a single map for each pair of key and value types, a map
+// with the same types replaces the map of the parent context.
+func NewContext[K comparable, V any](ctx context.Context, m *Map[K, V]) context.Context {
+	return context.WithValue(ctx, contextKey[K, V]{}, m)
+}
+
+// FromContext returns the [Map] with the key type K and the value type V carried
+// by the context (See [NewContext]).
+//
+// The context value is looked up using the key of the type parameters, so the
+// type assertion of the value cannot fail, a map with different key or value
+// types is not found. The bool return value reports whether a map was found.
+func FromContext[K comparable, V any](ctx context.Context) (*Map[K, V], bool) {
+	m, ok := ctx.Value(contextKey[K, V]{}).(*Map[K, V])
+	return m, ok && m != nil
+}
@@ -0,0 +1,37 @@
+package xmap_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapContext(t *testing.T) {
+	t.Parallel()
+
+	users := xmap.New[string, int]()
+	defer users.Stop()
+
+	tokens := xmap.New[string, string]()
+	defer tokens.Stop()
+
+	ctx := xmap.NewContext(context.Background(), users)
+	ctx = xmap.NewContext(ctx, tokens)
+
+	if got, ok := xmap.FromContext[string, int](ctx); !ok || got != users {
+		t.Errorf("want map %p, got %p (found %t)", users, got, ok)
+	}
+
+	if got, ok := xmap.FromContext[string, string](ctx); !ok || got != tokens {
+		t.Errorf("want map %p, got %p (found %t)", tokens, got, ok)
+	}
+
+	if _, ok := xmap.FromContext[int, int](ctx); ok {
+		t.Error("want no map with different types")
+	}
+
+	if _, ok := xmap.FromContext[string, int](context.Background()); ok {
+		t.Error("want no map in an empty context")
+	}
+}
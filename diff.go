@@ -0,0 +1,124 @@
+package xmap
+
+// tombstone is a removal of a key recorded by the diff log (See [Config.DiffLogSize]).
+type tombstone[K comparable] struct {
+	key     K      // The removed key.
+	version uint64 // The version number assigned to the removal.
+}
+
+// SnapshotVersion returns a copy of the live key-value pairs of the [Map] and the
+// version of the [Map] at the time of the copy, the version can be passed to
+// [Map.DiffSince] to get the changes made after the snapshot.
+//
+// The copy is made while holding the read lock.
+func (m *Map[K, V]) SnapshotVersion() (map[K]V, uint64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snapshot := make(map[K]V, m.kv.Len())
+	for key, entry := range m.kv.Range {
+		if m.live(entry) {
+			snapshot[key] = entry.value
+		}
+	}
+	return snapshot, m.version
+}
+
+// DiffSince returns the changes made to the [Map] after the version returned by
+// [Map.SnapshotVersion], applying the changes to the snapshot of that version
+// results in the live keys of the [Map].
+//
+// Each write assigns a new version number to the key, the live keys that were
+// created after the version are returned in added, and the live keys that were
+// changed after the version are returned in updated with their current values and
+// expiration times, including the keys whose expiration time was changed without
+// changing their values (e.g. [RenewLease]). A key that was deleted and created
+// again is returned in added.
+//
+// The removals are recorded in a log that keeps the last [Config.DiffLogSize]
+// removals, the keys removed after the version are returned in removed along with
+// the expired keys that have not been removed yet. The removed keys might include
+// keys that were not in the snapshot (e.g. created and removed after the version).
+//
+// The ok return value is false if the changes since the version are not known,
+// which happens if the diff log is disabled, if the removals made after the
+// version were dropped from the log, if the [Map] was cleared after the version,
+// or if the version is newer than the current version of the [Map]. In this
+// case a new snapshot must be taken.
+func (m *Map[K, V]) DiffSince(version uint64) (added, updated []Entry[K, V], removed []K, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.diffLog <= 0 || version < m.diffFloor || version > m.version {
+		return nil, nil, nil, false
+	}
+
+	for key, entry := range m.kv.Range {
+		switch {
+		case !m.live(entry):
+			removed = append(removed, key)
+		case max(entry.version, entry.touched()) <= version:
+		case entry.born() > version:
+			added = append(added, Entry[K, V]{key, entry.value, entry.exp})
+		default:
+			updated = append(updated, Entry[K, V]{key, entry.value, entry.exp})
+		}
+	}
+
+	// The same key might have been removed multiple times.
+	seen := make(map[K]struct{})
+	for _, t := range m.tombs {
+		if _, dup := seen[t.key]; dup || t.version <= version {
+			continue
+		}
+		if _, ok := m.kv.Get(t.key); !ok {
+			seen[t.key] = struct{}{}
+			removed = append(removed, t.key)
+		}
+	}
+
+	return added, updated, removed, true
+}
+
+// trackStore sets the version at which the key was created on the record being
+// stored, the version of a live key that is replaced is preserved.
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) trackStore(key K, entry *Record[V]) {
+	born := entry.version
+	if old, ok := m.kv.Get(key); ok && m.live(old) {
+		born = old.born()
+	}
+	entry.metadata().born = born
+}
+
+// trackTouch records the change of the expiration time of the record made in place
+// without changing its version, so the key is reported as updated.
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) trackTouch(entry *Record[V]) {
+	if m.diffLog > 0 {
+		entry.metadata().touched = m.nextVersion()
+	}
+}
+
+// trackRemove records the removal of the key in the diff log and drops the oldest
+// removal if the log is full.
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) trackRemove(key K) {
+	m.tombs = append(m.tombs, tombstone[K]{key, m.nextVersion()})
+	if len(m.tombs) > m.diffLog {
+		// The changes since the dropped removal are no longer known.
+		m.diffFloor = m.tombs[0].version
+		m.tombs = m.tombs[1:]
+	}
+}
+
+// trackClear invalidates the diff log after all the keys were removed.
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) trackClear() {
+	m.diffFloor = m.nextVersion()
+	m.tombs = nil
+}
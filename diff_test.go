@@ -0,0 +1,189 @@
+package xmap_test
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapDiffSince(t *testing.T) {
+	t.Parallel()
+
+	testTime := newMockTime(time.Now())
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		DiffLogSize: 4,
+		TimeSource:  testTime,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	m.Set("b", 2, 0)
+	m.Set("c", 3, 0)
+	m.Set("d", 4, time.Minute)
+
+	snapshot, version := m.SnapshotVersion()
+
+	m.Set("a", 10, 0)  // Updated.
+	m.Update("b", 20)  // Updated.
+	m.Delete("c")      // Removed.
+	m.Set("e", 5, 0)   // Added.
+	m.Delete("b")      // Removed.
+	m.Set("b", 200, 0) // Added again.
+	testTime.Advance(2 * time.Minute)
+
+	added, updated, removed, ok := m.DiffSince(version)
+	if !ok {
+		t.Fatal("want known changes")
+	}
+
+	// Apply the changes to the snapshot.
+	for _, e := range slices.Concat(added, updated) {
+		snapshot[e.Key] = e.Value
+	}
+	for _, key := range removed {
+		delete(snapshot, key)
+	}
+
+	if want := maps.Collect(m.All()); !maps.Equal(want, snapshot) {
+		t.Errorf("want %v, got %v", want, snapshot)
+	}
+
+	keys := func(entries []xmap.Entry[string, int]) []string {
+		var keys []string
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+		return slices.Sorted(slices.Values(keys))
+	}
+
+	if want := []string{"b", "e"}; !slices.Equal(want, keys(added)) {
+		t.Errorf("want added keys %v, got %v", want, keys(added))
+	}
+
+	if want := []string{"a"}; !slices.Equal(want, keys(updated)) {
+		t.Errorf("want updated keys %v, got %v", want, keys(updated))
+	}
+
+	if want := []string{"c", "d"}; !slices.Equal(want, slices.Sorted(slices.Values(removed))) {
+		t.Errorf("want removed keys %v, got %v", want, removed)
+	}
+}
+
+func TestMapDiffSinceUnknown(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{DiffLogSize: 2})
+	defer m.Stop()
+
+	for _, key := range []string{"a", "b", "c"} {
+		m.Set(key, 1, 0)
+	}
+
+	_, version := m.SnapshotVersion()
+
+	if _, _, _, ok := m.DiffSince(version + 1); ok {
+		t.Error("want unknown changes for a future version")
+	}
+
+	m.Delete("a")
+	m.Delete("b")
+
+	if _, _, _, ok := m.DiffSince(version); !ok {
+		t.Error("want known changes while the removals are logged")
+	}
+
+	m.Delete("c") // Drops the oldest removal.
+
+	if _, _, _, ok := m.DiffSince(version); ok {
+		t.Error("want unknown changes after the removals are dropped")
+	}
+
+	m.Set("a", 1, 0)
+	_, version = m.SnapshotVersion()
+	m.Clear()
+
+	if _, _, _, ok := m.DiffSince(version); ok {
+		t.Error("want unknown changes after clearing the map")
+	}
+
+	disabled := xmap.New[string, int]()
+	defer disabled.Stop()
+
+	if _, _, _, ok := disabled.DiffSince(0); ok {
+		t.Error("want unknown changes when the diff log is disabled")
+	}
+}
+
+func TestMapDiffSinceMissingKeys(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{DiffLogSize: 2})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	_, version := m.SnapshotVersion()
+
+	m.Delete("a")
+	m.Delete("missing1") // Not held, not logged.
+	m.Delete("missing2")
+
+	_, _, removed, ok := m.DiffSince(version)
+	if !ok {
+		t.Fatal("want known changes when deleting missing keys")
+	}
+
+	if want := []string{"a"}; !slices.Equal(want, removed) {
+		t.Errorf("want removed keys %v, got %v", want, removed)
+	}
+}
+
+func TestMapDiffSinceExpirationChanges(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, string](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+		DiffLogSize:        4,
+	})
+	defer m.Stop()
+
+	if !xmap.AcquireLease(m, "lock", "owner", time.Second) {
+		t.Fatal("want lease to be acquired")
+	}
+	m.Set("a", "1", time.Minute)
+
+	_, version := m.SnapshotVersion()
+
+	if !xmap.RenewLease(m, "lock", "owner", time.Hour) {
+		t.Fatal("want lease to be renewed")
+	}
+
+	_, updated, _, ok := m.DiffSince(version)
+	if !ok {
+		t.Fatal("want known changes")
+	}
+
+	want := []xmap.Entry[string, string]{{Key: "lock", Value: "owner", Expiration: now.Add(time.Hour)}}
+	if !slices.EqualFunc(want, updated, func(a, b xmap.Entry[string, string]) bool {
+		return a.Key == b.Key && a.Value == b.Value && a.Expiration.Equal(b.Expiration)
+	}) {
+		t.Errorf("want updated entries %v, got %v", want, updated)
+	}
+
+	// The snapshot taken after the change does not report it again.
+	_, version = m.SnapshotVersion()
+	if added, updated, removed, _ := m.DiffSince(version); len(added)+len(updated)+len(removed) != 0 {
+		t.Errorf("want no changes, got %v, %v, %v", added, updated, removed)
+	}
+
+	if err := m.CheckInvariants(); err != nil {
+		t.Errorf("unexpected invariant violation: %v", err)
+	}
+}
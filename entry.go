@@ -0,0 +1,35 @@
+package xmap
+
+import "time"
+
+// Entry represents a key-value pair of the [Map] with its expiration time.
+type Entry[K comparable, V any] struct {
+	// Key is the key of the entry.
+	Key K
+	// Value is the value associated with the key.
+	Value V
+	// Expiration is the expiration time of the key.
+	// A zero time value means that the key never expires.
+	Expiration time.Time
+}
+
+// EntryView is a consistent view of a key of the [Map] with its metadata returned
+// by [Map.Inspect].
+//
+// The metadata that is not tracked by the [Map] has a zero value.
+type EntryView[K comparable, V any] struct {
+	// Key is the key of the entry.
+	Key K
+	// Value is the value associated with the key.
+	Value V
+	// Expiration is the expiration time of the key.
+	// A zero time value means that the key never expires.
+	Expiration time.Time
+	// Version is the version number of the key (See [Map.GetVersioned]).
+	Version uint64
+	// Created is the creation time of the key (See [Map.Age]).
+	Created time.Time
+	// LastAccess is the last time the key was read or modified.
+	// It's only set when [Config.TrackAccess] is enabled.
+	LastAccess time.Time
+}
@@ -0,0 +1,104 @@
+package xmap
+
+import "sync"
+
+// call is an in-flight or completed function call of a [flight].
+type call[V any] struct {
+	done  chan struct{} // Channel closed when the call completes.
+	value V             // The returned value.
+	err   error         // The returned error.
+	ok    bool          // Reports whether the function returned without panicking.
+}
+
+// flight collapses the concurrent function calls for the same key into a single call.
+//
+// The zero value is ready to use.
+type flight[K comparable, V any] struct {
+	mu    sync.Mutex     // Mutex to synchronize the calls access.
+	calls map[K]*call[V] // In-flight calls by key.
+}
+
+// do calls the function fn and returns its results, making sure that only one call
+// is in-flight for a key at a time, the concurrent callers for the same key wait for
+// the in-flight call and receive the same results.
+//
+// If fn panics, the panic is propagated to its caller and the waiting callers retry.
+func (f *flight[K, V]) do(key K, fn func() (V, error)) (V, error) {
+	for {
+		f.mu.Lock()
+		if c, ok := f.calls[key]; ok {
+			f.mu.Unlock()
+			<-c.done
+			if !c.ok {
+				continue // The call panicked.
+			}
+			return c.value, c.err
+		}
+
+		c := &call[V]{done: make(chan struct{})}
+		if f.calls == nil {
+			f.calls = make(map[K]*call[V])
+		}
+		f.calls[key] = c
+		f.mu.Unlock()
+
+		defer func() {
+			f.mu.Lock()
+			delete(f.calls, key)
+			f.mu.Unlock()
+			close(c.done)
+		}()
+
+		c.value, c.err = fn()
+		c.ok = true
+		return c.value, c.err
+	}
+}
+
+// claim registers an in-flight call for each of the keys that has no in-flight call,
+// the registered calls are returned in owned and the in-flight calls of the other
+// keys are returned in pending.
+//
+// The caller must set the results of the owned calls and complete them using
+// [flight.release], the calls that are released without setting ok make their
+// waiting callers retry.
+func (f *flight[K, V]) claim(keys []K) (owned, pending map[K]*call[V]) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.calls == nil {
+		f.calls = make(map[K]*call[V])
+	}
+
+	owned = make(map[K]*call[V], len(keys))
+	for _, key := range keys {
+		if c, ok := f.calls[key]; ok {
+			if owned[key] == nil {
+				if pending == nil {
+					pending = make(map[K]*call[V])
+				}
+				pending[key] = c
+			}
+			continue
+		}
+
+		c := &call[V]{done: make(chan struct{})}
+		f.calls[key] = c
+		owned[key] = c
+	}
+
+	return owned, pending
+}
+
+// release completes the calls claimed using [flight.claim].
+func (f *flight[K, V]) release(owned map[K]*call[V]) {
+	f.mu.Lock()
+	for key := range owned {
+		delete(f.calls, key)
+	}
+	f.mu.Unlock()
+
+	for _, c := range owned {
+		close(c.done)
+	}
+}
@@ -0,0 +1,55 @@
+package xmap
+
+import "errors"
+
+// ErrFrozen is returned by [Map.Transaction] when the [Map] is frozen.
+var ErrFrozen = errors.New("xmap: map is frozen")
+
+// Freeze puts the [Map] in a read-only state until [Map.Unfreeze] is called.
+//
+// The methods that modify the [Map] are no-ops while the [Map] is frozen, the
+// methods that report a change return false (e.g. [Map.Update]) or a zero count
+// (e.g. [Map.Trim]), and [Map.Transaction] returns [ErrFrozen]. The reads and the
+// iterations are not affected.
+//
+// If [Config.FreezeBlocksWrites] is set, the methods that modify the [Map] block
+// until the [Map] is unfrozen (or stopped) instead, which allows taking consistent
+// snapshots across multiple reads (e.g. for backups) without holding a lock.
+// Calling a method that modifies a frozen [Map] from the goroutine that is
+// responsible for calling [Map.Unfreeze] causes a deadlock.
+//
+// The expired keys are still treated as missing, and they are still removed by the
+// cleanup goroutine and [Map.RemoveExpired] unless [Config.FreezeCleanup] is set,
+// in which case the contents of a frozen [Map] are fully stable.
+func (m *Map[K, V]) Freeze() {
+	m.mu.Lock()
+	m.frozen = true
+	m.mu.Unlock()
+}
+
+// Unfreeze reverts the [Map] to the read-write state after a call to [Map.Freeze].
+func (m *Map[K, V]) Unfreeze() {
+	m.mu.Lock()
+	m.frozen = false
+	m.thaw.Broadcast()
+	m.mu.Unlock()
+}
+
+// Frozen reports whether the [Map] is frozen (See [Map.Freeze]).
+func (m *Map[K, V]) Frozen() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.frozen
+}
+
+// writable reports whether the [Map] can be modified, if the [Map] is frozen and
+// [Config.FreezeBlocksWrites] is set, it waits until the [Map] is unfrozen or stopped.
+//
+// The write lock must be held when calling this method, it's released while waiting
+// so the state of the [Map] must be read after calling this method.
+func (m *Map[K, V]) writable() bool {
+	for m.frozen && m.blockFrz && !m.Stopped() {
+		m.thaw.Wait()
+	}
+	return !m.frozen
+}
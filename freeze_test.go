@@ -0,0 +1,203 @@
+package xmap_test
+
+import (
+	"errors"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapFreeze(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	m.Set("b", 2, time.Minute)
+
+	m.Freeze()
+	if !m.Frozen() {
+		t.Fatal("want map frozen")
+	}
+
+	m.Set("c", 3, 0)
+	m.Delete("a")
+	m.Clear()
+
+	if m.Update("a", 10) {
+		t.Error("want update to fail on a frozen map")
+	}
+
+	if value, exp, ok := m.GetOrSetWithExpiration("d", 4, time.Minute); ok || value != 0 || !exp.IsZero() {
+		t.Errorf("want zero values for a key not set on a frozen map, got %d, %v, %v", value, exp, ok)
+	}
+
+	if value, ok := m.GetOrSet("a", 10, 0); !ok || value != 1 {
+		t.Errorf("want existing value %d, got %d (ok %v)", 1, value, ok)
+	}
+
+	err := m.Transaction(func(tx *xmap.Tx[string, int]) error { return nil })
+	if !errors.Is(err, xmap.ErrFrozen) {
+		t.Errorf("want error %v, got %v", xmap.ErrFrozen, err)
+	}
+
+	if got := m.Len(); got != 2 {
+		t.Errorf("want length %d, got %d", 2, got)
+	}
+
+	if value, _ := m.Get("a"); value != 1 {
+		t.Errorf("want value %d, got %d", 1, value)
+	}
+
+	// The cleanup is not paused by default.
+	testTime.Advance(2 * time.Minute)
+	if removed := m.RemoveExpired(); removed != 1 {
+		t.Errorf("want %d removed keys, got %d", 1, removed)
+	}
+
+	m.Unfreeze()
+	m.Set("c", 3, 0)
+
+	if _, ok := m.Get("c"); !ok {
+		t.Error("want key set after unfreezing")
+	}
+}
+
+func TestMapFreezeCleanup(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+		FreezeCleanup:      true,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, time.Minute)
+	m.Freeze()
+
+	testTime.Advance(2 * time.Minute)
+	if removed := m.RemoveExpired(); removed != 0 {
+		t.Errorf("want no removed keys while frozen, got %d", removed)
+	}
+
+	m.Unfreeze()
+	if removed := m.RemoveExpired(); removed != 1 {
+		t.Errorf("want %d removed keys, got %d", 1, removed)
+	}
+}
+
+func TestMapFreezeBlocksWrites(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		FreezeBlocksWrites: true,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	m.Freeze()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Set("a", 2, 0)
+	}()
+
+	// The reads proceed while the write is blocked.
+	for range 10 {
+		if value, _ := m.Get("a"); value != 1 {
+			t.Fatalf("want value %d while frozen, got %d", 1, value)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("want write blocked while frozen")
+	default:
+	}
+
+	m.Unfreeze()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("write not unblocked after unfreezing")
+	}
+
+	if value, _ := m.Get("a"); value != 2 {
+		t.Errorf("want value %d after unfreezing, got %d", 2, value)
+	}
+}
+
+func TestMapFreezeBlockedWritesStop(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		FreezeBlocksWrites: true,
+	})
+
+	m.Freeze()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Delete("a")
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	m.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("write not unblocked after stopping")
+	}
+}
+
+func TestMapFreezeBlockedWritesDrainAndStop(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		FreezeBlocksWrites: true,
+	})
+
+	m.Set("a", 1, 0)
+	m.Freeze()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Set("b", 2, 0)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	drained := make(map[string]int)
+	m.DrainAndStop(func(k string, v int) {
+		drained[k] = v
+	})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("write not unblocked after draining")
+	}
+
+	if want := map[string]int{"a": 1}; !maps.Equal(want, drained) {
+		t.Errorf("want drained entries %v, got %v", want, drained)
+	}
+}
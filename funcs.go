@@ -0,0 +1,137 @@
+package xmap
+
+import (
+	"cmp"
+	"time"
+)
+
+// The methods that require a constrained value type are implemented as functions
+// since a method cannot add constraints to the type parameters of the [Map].
+
+// SetIfChanged creates or replaces a key-value pair in the [Map] only if the value
+// is different from the current value of the key.
+//
+// The key is always set if it does not exist. If the current value is equal to
+// the new value, neither the value nor the expiration time are changed.
+//
+// The return value reports whether the key was set.
+func SetIfChanged[K, V comparable](m *Map[K, V], key K, value V, ttl time.Duration) bool {
+	entry := m.newRecord(value, ttl)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return false
+	}
+
+	if current, ok := m.kv.Get(key); ok && m.live(current) && current.value == value {
+		return false
+	}
+
+	return m.store(key, entry)
+}
+
+// SetIfGreater stores the value of the key only if it's greater than the current
+// value of the key, or if the key does not exist.
+//
+// The expiration time of an existing key is preserved when its value is replaced,
+// the ttl is only applied when the key is created.
+// A key can be set to never expire with a ttl value of 0.
+//
+// The return value reports whether the value was stored.
+func SetIfGreater[K comparable, V cmp.Ordered](m *Map[K, V], key K, value V, ttl time.Duration) bool {
+	return setIfOrdered(m, key, value, ttl, 1)
+}
+
+// SetIfLess stores the value of the key only if it's less than the current value
+// of the key, or if the key does not exist.
+//
+// Similar to [SetIfGreater], the expiration time of an existing key is preserved
+// and the ttl is only applied when the key is created.
+//
+// The return value reports whether the value was stored.
+func SetIfLess[K comparable, V cmp.Ordered](m *Map[K, V], key K, value V, ttl time.Duration) bool {
+	return setIfOrdered(m, key, value, ttl, -1)
+}
+
+// setIfOrdered stores the value of the key only if the result of comparing it
+// to the current value of the key is equal to want, or if the key does not exist.
+func setIfOrdered[K comparable, V cmp.Ordered](m *Map[K, V], key K, value V, ttl time.Duration, want int) bool {
+	entry := m.newRecord(value, ttl)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return false
+	}
+
+	current, ok := m.kv.Get(key)
+	if !ok || !m.live(current) {
+		return m.store(key, entry)
+	}
+
+	if cmp.Compare(value, current.value) != want {
+		return false
+	}
+
+	current.value = value
+	current.modified = entry.modified
+	current.version = m.nextVersion()
+	return true
+}
+
+// AcquireLease sets the key to the owner value only if the key does not exist or
+// it has expired, which acquires an expiring lease of the key for the owner.
+//
+// The owners are compared using the == operator, so the value type must be
+// comparable and each owner must have a distinct value (e.g. an instance ID).
+// A lease expires after the ttl unless it's renewed using [RenewLease], once
+// expired it can be acquired by any owner even if the expired key has not been
+// removed yet. A ttl value of 0 makes the lease never expire.
+//
+// The return value reports whether the lease was acquired, it's false if the
+// lease is held, including by the same owner which must renew it instead.
+func AcquireLease[K, V comparable](m *Map[K, V], key K, owner V, ttl time.Duration) bool {
+	entry := m.newRecord(owner, ttl)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return false
+	}
+
+	if current, ok := m.kv.Get(key); ok && m.live(current) {
+		return false
+	}
+
+	return m.store(key, entry)
+}
+
+// RenewLease extends the lease of the key acquired using [AcquireLease] by setting
+// its expiration time to the current time plus the ttl, only if the lease is held
+// by the owner and it has not expired.
+//
+// The return value reports whether the lease was renewed, an expired lease cannot
+// be renewed and it must be acquired again.
+func RenewLease[K, V comparable](m *Map[K, V], key K, owner V, ttl time.Duration) bool {
+	m.checkTTL(ttl)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return false
+	}
+
+	current, ok := m.kv.Get(key)
+	if !ok || !m.live(current) || current.value != owner {
+		return false
+	}
+
+	current.exp = m.expiration(m.time.Now(), ttl)
+	m.trackTouch(current)
+	return true
+}
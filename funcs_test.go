@@ -0,0 +1,136 @@
+package xmap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestSetIfChanged(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	if ok := xmap.SetIfChanged(m, "a", 1, time.Minute); !ok {
+		t.Fatalf("key %q was not set when it does not exist", "a")
+	}
+
+	testTime.Advance(time.Second)
+
+	// Same value, the expiration time is not changed.
+	if ok := xmap.SetIfChanged(m, "a", 1, time.Hour); ok {
+		t.Errorf("key %q was set with the same value", "a")
+	}
+
+	if _, exp, _ := m.GetWithExpiration("a"); !exp.Equal(now.Add(time.Minute)) {
+		t.Errorf("want expiration %v, got %v", now.Add(time.Minute), exp)
+	}
+
+	if ok := xmap.SetIfChanged(m, "a", 2, time.Hour); !ok {
+		t.Errorf("key %q was not set with a different value", "a")
+	}
+
+	wantExp := testTime.Now().Add(time.Hour)
+	if value, exp, _ := m.GetWithExpiration("a"); value != 2 || !exp.Equal(wantExp) {
+		t.Errorf("want value %d with expiration %v, got %d with expiration %v", 2, wantExp, value, exp)
+	}
+}
+
+func TestSetIfGreaterAndLess(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	if ok := xmap.SetIfGreater(m, "max", 5, time.Minute); !ok {
+		t.Fatalf("key %q was not set when it does not exist", "max")
+	}
+
+	if ok := xmap.SetIfGreater(m, "max", 3, time.Hour); ok {
+		t.Error("want smaller value not stored")
+	}
+
+	if ok := xmap.SetIfGreater(m, "max", 8, time.Hour); !ok {
+		t.Error("want greater value stored")
+	}
+
+	// The expiration time is preserved.
+	if value, exp, _ := m.GetWithExpiration("max"); value != 8 || !exp.Equal(now.Add(time.Minute)) {
+		t.Errorf("want value %d expiring at %v, got %d expiring at %v", 8, now.Add(time.Minute), value, exp)
+	}
+
+	xmap.SetIfLess(m, "min", 5, 0)
+
+	if ok := xmap.SetIfLess(m, "min", 5, 0); ok {
+		t.Error("want equal value not stored")
+	}
+
+	if ok := xmap.SetIfLess(m, "min", 2, 0); !ok {
+		t.Error("want smaller value stored")
+	}
+
+	if value, _ := m.Get("min"); value != 2 {
+		t.Errorf("want value %d, got %d", 2, value)
+	}
+}
+
+func TestLease(t *testing.T) {
+	t.Parallel()
+
+	testTime := newMockTime(time.Now())
+
+	m := xmap.NewWithConfig[string, string](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	if !xmap.AcquireLease(m, "leader", "node-1", time.Minute) {
+		t.Fatal("want lease to be acquired")
+	}
+
+	if xmap.AcquireLease(m, "leader", "node-2", time.Minute) {
+		t.Error("want held lease to not be acquired by another owner")
+	}
+
+	if xmap.AcquireLease(m, "leader", "node-1", time.Minute) {
+		t.Error("want held lease to not be acquired again by its owner")
+	}
+
+	testTime.Advance(45 * time.Second)
+
+	if xmap.RenewLease(m, "leader", "node-2", time.Minute) {
+		t.Error("want lease to not be renewed by another owner")
+	}
+
+	if !xmap.RenewLease(m, "leader", "node-1", time.Minute) {
+		t.Error("want lease to be renewed by its owner")
+	}
+
+	testTime.Advance(45 * time.Second) // Still held after the renewal.
+
+	if xmap.AcquireLease(m, "leader", "node-2", time.Minute) {
+		t.Error("want renewed lease to be held")
+	}
+
+	testTime.Advance(time.Minute) // Expired.
+
+	if xmap.RenewLease(m, "leader", "node-1", time.Minute) {
+		t.Error("want expired lease to not be renewed")
+	}
+
+	if !xmap.AcquireLease(m, "leader", "node-2", time.Minute) {
+		t.Error("want expired lease to be acquired by another owner")
+	}
+}
@@ -0,0 +1,169 @@
+package xmap
+
+import "time"
+
+// group is a set of keys that do not outlive the group expiration.
+//
+// The members of a group are tracked in an index, the keys in the index are
+// not removed when a member is deleted or replaced, the stale keys are pruned
+// when the expired keys are removed.
+type group[K comparable] struct {
+	id   uint64         // The group ID stored in the member records.
+	exp  time.Time      // The expiration time of the group.
+	keys map[K]struct{} // The member keys.
+}
+
+// SetGroup creates or replaces a group of keys that expires after the specified ttl.
+//
+// A group can be set to never expire with a ttl value of 0.
+//
+// The keys are added to a group using [Map.SetInGroup], the expiration time of
+// the existing members is shortened if it's after the new group expiration time.
+//
+// The group names are independent from the [Map] keys.
+func (m *Map[K, V]) SetGroup(name K, ttl time.Duration) {
+	m.checkTTL(ttl)
+
+	now := m.time.Now()
+
+	var exp time.Time
+
+	if ttl > 0 {
+		exp = now.Add(ttl)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return
+	}
+
+	if g, ok := m.groups[name]; ok && !m.groupExpired(g, now) {
+		g.exp = exp
+
+		if !exp.IsZero() {
+			// Clamp the members expiration to the group expiration.
+			for key := range g.keys {
+				if entry, ok := m.kv.Get(key); ok && entry.group() == g.id {
+					if entry.exp.IsZero() || entry.exp.After(exp) {
+						entry.exp = exp
+						m.trackTouch(entry)
+					}
+				}
+			}
+		}
+		return
+	}
+
+	if m.groups == nil {
+		m.groups = make(map[K]*group[K])
+	}
+
+	m.groupID++
+	m.groups[name] = &group[K]{
+		id:   m.groupID,
+		exp:  exp,
+		keys: make(map[K]struct{}),
+	}
+}
+
+// SetInGroup creates or replaces a key-value pair in the [Map] as a member of a group.
+//
+// The effective expiration time of the key is the earliest of the key and the
+// group expiration times, so a key never outlives its group.
+// A key can be set to never expire with a ttl value of 0, in which case it
+// expires with the group.
+//
+// The return value reports whether the key was set (Group exists), the groups
+// are created using [Map.SetGroup].
+func (m *Map[K, V]) SetInGroup(name, key K, value V, ttl time.Duration) bool {
+	m.checkWrite(ttl)
+	m.checkValue(value)
+
+	now := m.time.Now()
+	exp := m.expiration(now, ttl)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return false
+	}
+
+	g, ok := m.groups[name]
+	if !ok || m.groupExpired(g, now) {
+		return false
+	}
+
+	if !g.exp.IsZero() && (exp.IsZero() || exp.After(g.exp)) {
+		exp = g.exp
+	}
+
+	entry := &Record[V]{value: value, exp: exp, created: now, modified: now}
+	entry.metadata().group = g.id
+	if !m.store(key, entry) {
+		return false
+	}
+	g.keys[key] = struct{}{}
+
+	return true
+}
+
+// DeleteGroup removes a group and all of its member keys from the [Map].
+//
+// It returns the number of member keys that were removed.
+func (m *Map[K, V]) DeleteGroup(name K) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return 0
+	}
+
+	g, ok := m.groups[name]
+	if !ok {
+		return 0
+	}
+
+	var removed int
+	for key := range g.keys {
+		// Skip the keys that were replaced after joining the group.
+		if entry, ok := m.kv.Get(key); ok && entry.group() == g.id {
+			m.remove(key)
+			removed++
+		}
+	}
+
+	delete(m.groups, name)
+
+	return removed
+}
+
+// removeExpiredGroups removes the expired groups from the index and prunes the
+// stale keys of the remaining groups.
+//
+// The members of an expired group have already expired since their expiration
+// time is never after the group expiration time, so only the groups index is
+// scanned, not the whole [Map].
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) removeExpiredGroups(now time.Time) {
+	for name, g := range m.groups {
+		if m.groupExpired(g, now) {
+			delete(m.groups, name)
+			continue
+		}
+
+		for key := range g.keys {
+			if entry, ok := m.kv.Get(key); !ok || entry.group() != g.id {
+				delete(g.keys, key)
+			}
+		}
+	}
+}
+
+// groupExpired reports whether a group has expired at the time now.
+func (m *Map[K, V]) groupExpired(g *group[K], now time.Time) bool {
+	return !g.exp.IsZero() && now.After(g.exp)
+}
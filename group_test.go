@@ -0,0 +1,87 @@
+package xmap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapGroupExpiration(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	if ok := m.SetInGroup("group", "a", 1, 0); ok {
+		t.Fatal("want false setting a key in a non existing group, got true")
+	}
+
+	m.SetGroup("group", time.Hour)
+
+	if ok := m.SetInGroup("group", "a", 1, 0); !ok {
+		t.Fatal("key was not set in the group")
+	}
+
+	m.SetInGroup("group", "b", 2, time.Minute)
+	m.SetInGroup("group", "c", 3, 2*time.Hour)
+
+	// The members do not outlive the group.
+	wantExpirations := map[string]time.Time{
+		"a": now.Add(time.Hour),
+		"b": now.Add(time.Minute),
+		"c": now.Add(time.Hour),
+	}
+
+	for key, want := range wantExpirations {
+		if _, got, ok := m.GetWithExpiration(key); !ok || !want.Equal(got) {
+			t.Errorf("want key %q expiration %v, got %v", key, want, got)
+		}
+	}
+
+	// Advance the time to make the group expire.
+	testTime.Advance(time.Hour + time.Nanosecond)
+
+	if removed := m.RemoveExpired(); removed != 3 {
+		t.Errorf("want %d key removals, got %d", 3, removed)
+	}
+
+	if ok := m.SetInGroup("group", "a", 1, 0); ok {
+		t.Error("want false setting a key in an expired group, got true")
+	}
+}
+
+func TestMapDeleteGroup(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, int]()
+	defer m.Stop()
+
+	m.SetGroup("group", 0)
+	m.SetInGroup("group", "a", 1, 0)
+	m.SetInGroup("group", "b", 2, time.Hour)
+	m.SetInGroup("group", "c", 3, time.Hour)
+
+	// Replaced keys are no longer members of the group.
+	m.Set("c", 30, 0)
+	m.Set("d", 4, 0)
+
+	if removed := m.DeleteGroup("group"); removed != 2 {
+		t.Errorf("want %d key removals, got %d", 2, removed)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if _, ok := m.Get(key); ok {
+			t.Errorf("key %q was not removed with the group", key)
+		}
+	}
+
+	if m.Len() != 2 {
+		t.Errorf("want map length %d, got %d", 2, m.Len())
+	}
+}
@@ -0,0 +1,107 @@
+package xmap
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"reflect"
+)
+
+// hashKey returns the hash of the key using the seed, the equal keys have equal hashes.
+//
+// The common key types are hashed directly, the other comparable types are hashed by
+// walking their values using reflection following the rules of the == operator
+// (The [maphash.Comparable] function requires Go 1.24).
+func hashKey[K comparable](seed maphash.Seed, key K) uint64 {
+	switch k := any(key).(type) {
+	case string:
+		return maphash.String(seed, k)
+	case int:
+		return hashUint64(seed, uint64(k))
+	case int64:
+		return hashUint64(seed, uint64(k))
+	case int32:
+		return hashUint64(seed, uint64(k))
+	case uint:
+		return hashUint64(seed, uint64(k))
+	case uint64:
+		return hashUint64(seed, k)
+	case uint32:
+		return hashUint64(seed, uint64(k))
+	}
+
+	var h maphash.Hash
+	h.SetSeed(seed)
+	writeValue(&h, reflect.ValueOf(&key).Elem())
+	return h.Sum64()
+}
+
+// hashUint64 returns the hash of the integer n using the seed.
+func hashUint64(seed maphash.Seed, n uint64) uint64 {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], n)
+	return maphash.Bytes(seed, b[:])
+}
+
+// writeValue writes the comparable value v to the hash h, the values that are equal
+// using the == operator are written identically.
+//
+// It panics if the value is not comparable (e.g. an interface holding a slice),
+// like the == operator.
+func writeValue(h *maphash.Hash, v reflect.Value) {
+	var b [8]byte
+
+	writeUint64 := func(n uint64) {
+		binary.LittleEndian.PutUint64(b[:], n)
+		h.Write(b[:])
+	}
+
+	writeFloat := func(f float64) {
+		if f == 0 {
+			f = 0 // -0 == +0.
+		}
+		writeUint64(math.Float64bits(f))
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			writeUint64(1)
+		} else {
+			writeUint64(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeUint64(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeUint64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		writeFloat(v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		writeFloat(real(c))
+		writeFloat(imag(c))
+	case reflect.String:
+		h.WriteString(v.String())
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		writeUint64(uint64(v.Pointer()))
+	case reflect.Interface:
+		// The dynamic type is not written, the values of different types collide.
+		if !v.IsNil() {
+			writeValue(h, v.Elem())
+		}
+	case reflect.Array:
+		for i := range v.Len() {
+			writeValue(h, v.Index(i))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := range v.NumField() {
+			// The blank fields are ignored by the == operator.
+			if t.Field(i).Name != "_" {
+				writeValue(h, v.Field(i))
+			}
+		}
+	default:
+		panic("xmap: hash of unhashable type " + v.Type().String())
+	}
+}
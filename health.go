@@ -0,0 +1,66 @@
+package xmap
+
+import "time"
+
+// HealthStatus is a summary of the [Map] state for the health checks.
+type HealthStatus struct {
+	// CleanupActive reports whether the cleanup goroutine is active (See [Map.CleanupActive]).
+	CleanupActive bool
+	// Stopped reports whether the [Map] is stopped (See [Map.Stopped]).
+	Stopped bool
+	// Live is the number of live keys.
+	Live int
+	// Expired is the number of expired keys and cached loader errors that have
+	// not been removed yet.
+	Expired int
+	// LastCleanup is the time of the last cleanup pass of the cleanup goroutine,
+	// a zero time value means that no cleanup pass has completed yet.
+	LastCleanup time.Time
+	// SinceLastCleanup is the time elapsed since the last cleanup pass, or since the
+	// [Map] was created if no cleanup pass has completed yet.
+	SinceLastCleanup time.Duration
+}
+
+// Health returns a summary of the [Map] state.
+//
+// The keys are counted while holding the read lock, so the cost is O(n).
+// The time of the last cleanup pass is recorded atomically by the cleanup loop
+// (Including [Map.Run]) after each pass, so it can be read without a lock.
+func (m *Map[K, V]) Health() HealthStatus {
+	status := HealthStatus{
+		CleanupActive: m.CleanupActive(),
+		Stopped:       m.Stopped(),
+	}
+
+	m.mu.RLock()
+	now := m.time.Now()
+	for _, entry := range m.kv.Range {
+		if entry.err == nil && !m.expiredAt(entry, now) {
+			status.Live++
+		} else {
+			status.Expired++
+		}
+	}
+	m.mu.RUnlock()
+
+	since := m.epoch
+	if status.LastCleanup = m.LastCleanup(); !status.LastCleanup.IsZero() {
+		since = status.LastCleanup
+	}
+	status.SinceLastCleanup = now.Sub(since)
+
+	return status
+}
+
+// LastCleanup returns the time of the last cleanup pass of the cleanup goroutine
+// (Including [Map.Run]), a zero time value is returned if no cleanup pass has
+// completed yet.
+//
+// The time is read from the configured [Config.TimeSource] after each pass and
+// it's stored atomically, so this method does not acquire the lock.
+func (m *Map[K, V]) LastCleanup() time.Time {
+	if last := m.lastCleanup.Load(); last != nil {
+		return *last
+	}
+	return time.Time{}
+}
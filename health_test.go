@@ -0,0 +1,96 @@
+package xmap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapHealth(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		CleanupInterval: time.Minute,
+		TimeSource:      testTime,
+	})
+	defer m.Stop()
+
+	if isActive := retryUntil(time.Second, m.CleanupActive); !isActive {
+		t.Fatal("cleanup loop did not start in time")
+	}
+
+	m.Set("a", 1, 30*time.Second)
+	m.Set("b", 2, 0)
+
+	testTime.Advance(45 * time.Second)
+
+	want := xmap.HealthStatus{
+		CleanupActive:    true,
+		Live:             1,
+		Expired:          1,
+		SinceLastCleanup: 45 * time.Second,
+	}
+	if got := m.Health(); got != want {
+		t.Errorf("want health %+v, got %+v", want, got)
+	}
+
+	testTime.Advance(15 * time.Second)
+	testTime.Tick()
+
+	cleaned := retryUntil(time.Second, func() bool {
+		return !m.Health().LastCleanup.IsZero()
+	})
+	if !cleaned {
+		t.Fatal("cleanup pass not recorded")
+	}
+
+	testTime.Advance(10 * time.Second)
+
+	got := m.Health()
+	if !got.LastCleanup.Equal(now.Add(time.Minute)) || got.SinceLastCleanup != 10*time.Second {
+		t.Errorf("want last cleanup %v (%v ago), got %v (%v ago)",
+			now.Add(time.Minute), 10*time.Second, got.LastCleanup, got.SinceLastCleanup)
+	}
+
+	if got.Live != 1 || got.Expired != 0 {
+		t.Errorf("want %d live and %d expired keys, got %d and %d", 1, 0, got.Live, got.Expired)
+	}
+}
+
+func TestMapLastCleanup(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		CleanupInterval: time.Minute,
+		TimeSource:      testTime,
+	})
+	defer m.Stop()
+
+	if got := m.LastCleanup(); !got.IsZero() {
+		t.Errorf("want zero last cleanup time, got %v", got)
+	}
+
+	if isActive := retryUntil(time.Second, m.CleanupActive); !isActive {
+		t.Fatal("cleanup loop did not start in time")
+	}
+
+	for i := 1; i <= 2; i++ {
+		testTime.Advance(time.Minute)
+		testTime.Tick()
+
+		want := now.Add(time.Duration(i) * time.Minute)
+		cleaned := retryUntil(time.Second, func() bool {
+			return m.LastCleanup().Equal(want)
+		})
+		if !cleaned {
+			t.Errorf("want last cleanup time %v, got %v", want, m.LastCleanup())
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package xmap_test
 
 import (
+	"runtime"
 	"sync"
 	"time"
 
@@ -15,6 +16,10 @@ func retryUntil(d time.Duration, f func() bool) bool {
 		if f() {
 			return true
 		}
+		// Yield to the goroutine being waited on, with a single P (GOMAXPROCS=1) the
+		// busy loop can use the whole deadline before the goroutine is scheduled
+		// (e.g. the cleanup goroutine in TestMapKeyExpirationAndRemoval).
+		runtime.Gosched()
 	}
 	return false
 }
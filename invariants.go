@@ -0,0 +1,114 @@
+package xmap
+
+import "fmt"
+
+// CheckInvariants verifies the internal consistency of the [Map] and returns
+// a descriptive error for the first inconsistency found.
+//
+// It walks all the internal structures while holding the write lock, so it's
+// costly and meant to be used by tests and fuzzers as a single assertion point.
+//
+// The checked invariants include the consistency of the group index, the record
+// versions, the length of the [Map] against the [Backend] length and the order
+// of the diff log (See [Config.DiffLogSize]).
+func (m *Map[K, V]) CheckInvariants() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.time.Now()
+
+	// Group IDs of the groups in the index.
+	groups := make(map[uint64]*group[K], len(m.groups))
+	for name, g := range m.groups {
+		if g.id == 0 || g.id > m.groupID {
+			return fmt.Errorf("xmap: group %v has an invalid ID %d", name, g.id)
+		}
+
+		if _, ok := groups[g.id]; ok {
+			return fmt.Errorf("xmap: group %v has a duplicate ID %d", name, g.id)
+		}
+
+		groups[g.id] = g
+	}
+
+	var count int
+	for key, entry := range m.kv.Range {
+		count++
+
+		if entry == nil {
+			return fmt.Errorf("xmap: key %v has a nil record", key)
+		}
+
+		if entry.err != nil && entry.exp.IsZero() {
+			return fmt.Errorf("xmap: negative record of key %v never expires", key)
+		}
+
+		if entry.version > m.version {
+			return fmt.Errorf("xmap: key %v has a version %d newer than the map version %d", key, entry.version, m.version)
+		}
+
+		if entry.touched() > m.version {
+			return fmt.Errorf("xmap: key %v has a touched version %d newer than the map version %d", key, entry.touched(), m.version)
+		}
+
+		if m.diffLog > 0 && entry.born() > entry.version {
+			return fmt.Errorf("xmap: key %v was created at version %d after its version %d", key, entry.born(), entry.version)
+		}
+
+		if entry.group() == 0 || m.expiredAt(entry, now) {
+			continue
+		}
+
+		g, ok := groups[entry.group()]
+		if !ok {
+			return fmt.Errorf("xmap: live key %v belongs to a missing group ID %d", key, entry.group())
+		}
+
+		if _, ok := g.keys[key]; !ok {
+			return fmt.Errorf("xmap: key %v is missing from the index of group ID %d", key, g.id)
+		}
+
+		if !g.exp.IsZero() && (entry.exp.IsZero() || entry.exp.After(g.exp)) {
+			return fmt.Errorf("xmap: key %v outlives its group ID %d", key, g.id)
+		}
+	}
+
+	if n := m.kv.Len(); n != count {
+		return fmt.Errorf("xmap: backend length %d does not match the number of records %d", n, count)
+	}
+
+	if n := m.count.Load(); n != int64(count) {
+		return fmt.Errorf("xmap: length %d does not match the number of records %d", n, count)
+	}
+
+	return m.checkDiffLog()
+}
+
+// checkDiffLog verifies that the removals of the diff log are ordered by version
+// between the floor version and the current version of the [Map], and that the
+// log does not exceed its size (See [Config.DiffLogSize]).
+//
+// The lock must be held when calling this method.
+func (m *Map[K, V]) checkDiffLog() error {
+	if m.diffLog == 0 && (len(m.tombs) > 0 || m.diffFloor > 0) {
+		return fmt.Errorf("xmap: diff log has %d removals while disabled", len(m.tombs))
+	}
+
+	if len(m.tombs) > m.diffLog {
+		return fmt.Errorf("xmap: diff log has %d removals, more than its size %d", len(m.tombs), m.diffLog)
+	}
+
+	if m.diffFloor > m.version {
+		return fmt.Errorf("xmap: diff log floor version %d is newer than the map version %d", m.diffFloor, m.version)
+	}
+
+	prev := m.diffFloor
+	for _, t := range m.tombs {
+		if t.version <= prev || t.version > m.version {
+			return fmt.Errorf("xmap: removal of key %v has an out of order version %d", t.key, t.version)
+		}
+		prev = t.version
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package xmap_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapCheckInvariants(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	check := func() {
+		t.Helper()
+		if err := m.CheckInvariants(); err != nil {
+			t.Fatalf("unexpected invariant violation: %v", err)
+		}
+	}
+
+	check()
+
+	m.Set("a", 1, time.Minute)
+	m.SetGroup("group", time.Hour)
+	m.SetInGroup("group", "b", 2, 0)
+	m.SetInGroup("group", "c", 3, 0)
+	m.Delete("c")
+	check()
+
+	// Replace the group after it expires while its members are not removed yet.
+	testTime.Advance(2 * time.Hour)
+	m.SetGroup("group", time.Hour)
+	check()
+
+	m.RemoveExpired()
+	check()
+}
+
+func TestMapCheckInvariantsViolation(t *testing.T) {
+	t.Parallel()
+
+	backend := &testBackend[string, int]{records: make(map[string]*xmap.Record[int])}
+
+	m := xmap.NewWithBackend(xmap.Config{DisableAutoCleanup: true}, backend)
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	if err := m.CheckInvariants(); err != nil {
+		t.Fatalf("unexpected invariant violation: %v", err)
+	}
+
+	// A record stored in the backend without going through the map.
+	backend.records["b"] = &xmap.Record[int]{}
+
+	if err := m.CheckInvariants(); err == nil {
+		t.Error("want invariant violation for a record added to the backend directly")
+	}
+}
+
+func FuzzMap(f *testing.F) {
+	f.Add([]byte{0, 1, 1, 2, 2, 1, 3, 1})
+	f.Add([]byte{4, 0, 5, 1, 5, 2, 6, 3, 7, 0, 8, 0})
+	f.Add([]byte{0, 1, 0, 2, 2, 1, 2, 2, 9, 1, 10, 0, 11, 0, 12, 3})
+	f.Add([]byte{5, 1, 5, 2, 13, 0, 7, 1, 2, 1, 14, 1, 15, 0, 0, 1})
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		testTime := newMockTime(time.Now())
+
+		m := xmap.NewWithConfig[uint8, int](xmap.Config{
+			TimeSource:         testTime,
+			DisableAutoCleanup: true,
+			DiffLogSize:        4,
+		})
+		defer m.Stop()
+
+		// apply runs the operation op on the key, the time is only advanced by the
+		// sequential operations since the mock time is not synchronized.
+		apply := func(op, key uint8, sequential bool) {
+			ttl := time.Duration(key%4) * time.Minute
+
+			switch op % 16 {
+			case 0:
+				m.Set(key, int(key), ttl)
+			case 1:
+				m.Set(key, int(key), 0)
+			case 2:
+				m.Delete(key)
+			case 3:
+				m.Update(key, int(key)+1)
+			case 4:
+				m.SetGroup(key%2, ttl)
+			case 5:
+				m.SetInGroup(key%2, key, int(key), ttl)
+			case 6:
+				m.DeleteGroup(key % 2)
+			case 7:
+				m.RemoveExpired()
+			case 8:
+				m.GetOrSet(key, int(key), ttl)
+			case 9:
+				m.Trim(int(key % 8))
+			case 10:
+				m.Compact()
+			case 11:
+				m.SetR(key, int(key), ttl)
+			case 12:
+				m.Get(key)
+			case 13:
+				if key%8 == 0 {
+					m.Clear()
+				}
+			case 14:
+				m.DiffSince(uint64(key))
+			case 15:
+				if sequential {
+					testTime.Advance(time.Duration(key) * time.Second)
+				}
+			}
+		}
+
+		check := func() {
+			t.Helper()
+			if err := m.CheckInvariants(); err != nil {
+				t.Fatalf("invariant violation: %v", err)
+			}
+		}
+
+		for i := 0; i+1 < len(ops); i += 2 {
+			apply(ops[i], ops[i+1], true)
+			check()
+		}
+
+		// Run the same operations concurrently, split between the workers.
+		const workers = 4
+
+		var wg sync.WaitGroup
+		for w := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 2 * w; i+1 < len(ops); i += 2 * workers {
+					apply(ops[i], ops[i+1], false)
+				}
+			}()
+		}
+		wg.Wait()
+
+		check()
+	})
+}
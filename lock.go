@@ -0,0 +1,150 @@
+package xmap
+
+import (
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LockMode is the locking strategy used to synchronize the [Map] access.
+type LockMode int
+
+const (
+	// LockWritePreferring uses a [sync.RWMutex], a blocked writer prevents new
+	// readers from acquiring the lock so the writers are not starved.
+	LockWritePreferring LockMode = iota
+	// LockReadPreferring uses a lock that lets new readers acquire the lock while
+	// a writer is waiting, a writer only acquires the lock when there are no readers.
+	//
+	// This reduces the read latency spikes caused by the writers (Including the
+	// cleanup) in read-dominated workloads, at the cost of a higher per-operation
+	// overhead and the possible starvation of the writers under a continuous read load.
+	LockReadPreferring
+)
+
+// rwLocker is a reader/writer mutual exclusion lock.
+type rwLocker interface {
+	Lock()
+	TryLock() bool
+	Unlock()
+	RLock()
+	RUnlock()
+}
+
+// newLocker returns the reader/writer lock of the lock mode.
+func newLocker(mode LockMode) rwLocker {
+	if mode == LockReadPreferring {
+		return newReadPreferringMutex()
+	}
+	return &sync.RWMutex{}
+}
+
+// readPreferringMutex is a reader/writer lock that gives the priority to the readers.
+type readPreferringMutex struct {
+	mu      sync.Mutex // Mutex to synchronize the state access.
+	cond    *sync.Cond // Condition signaled when the lock state changes.
+	readers int        // Number of readers holding the lock.
+	writer  bool       // Reports whether a writer holds the lock.
+}
+
+// newReadPreferringMutex creates a new unlocked [readPreferringMutex].
+func newReadPreferringMutex() *readPreferringMutex {
+	rw := &readPreferringMutex{}
+	rw.cond = sync.NewCond(&rw.mu)
+	return rw
+}
+
+// RLock locks rw for reading, it only waits if a writer holds the lock.
+func (rw *readPreferringMutex) RLock() {
+	rw.mu.Lock()
+	for rw.writer {
+		rw.cond.Wait()
+	}
+	rw.readers++
+	rw.mu.Unlock()
+}
+
+// RUnlock undoes a single RLock call.
+func (rw *readPreferringMutex) RUnlock() {
+	rw.mu.Lock()
+	rw.readers--
+	if rw.readers == 0 {
+		rw.cond.Broadcast()
+	}
+	rw.mu.Unlock()
+}
+
+// Lock locks rw for writing, it waits until there are no readers and no writer.
+func (rw *readPreferringMutex) Lock() {
+	rw.mu.Lock()
+	for rw.writer || rw.readers > 0 {
+		rw.cond.Wait()
+	}
+	rw.writer = true
+	rw.mu.Unlock()
+}
+
+// TryLock tries to lock rw for writing and reports whether it succeeded.
+func (rw *readPreferringMutex) TryLock() bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.writer || rw.readers > 0 {
+		return false
+	}
+	rw.writer = true
+	return true
+}
+
+// Unlock unlocks rw for writing.
+func (rw *readPreferringMutex) Unlock() {
+	rw.mu.Lock()
+	rw.writer = false
+	rw.cond.Broadcast()
+	rw.mu.Unlock()
+}
+
+// lockCounters are the lock contention counters of a [Map] (See [Config.TrackLockStats]).
+type lockCounters struct {
+	writes    atomic.Uint64 // Number of write lock acquisitions.
+	contended atomic.Uint64 // Number of write lock acquisitions that had to wait.
+	wait      atomic.Int64  // Total time waited for the write lock in nanoseconds.
+	cleanup   atomic.Int64  // Total time holding the write lock by the cleanup in nanoseconds.
+}
+
+// countingLocker is a reader/writer lock that counts the write lock contention.
+//
+// A write lock is first tried without waiting using TryLock, the acquisitions that
+// fail the fast path are counted as contended and the time spent waiting for the
+// lock is measured using the wall clock.
+type countingLocker struct {
+	rwLocker
+	counters *lockCounters
+}
+
+// Lock locks l for writing and updates the contention counters.
+func (l *countingLocker) Lock() {
+	l.counters.writes.Add(1)
+	if l.rwLocker.TryLock() {
+		return
+	}
+
+	l.counters.contended.Add(1)
+	start := time.Now()
+	l.rwLocker.Lock()
+	l.counters.wait.Add(int64(time.Since(start)))
+}
+
+// lockedSource is a [rand.Source] that is safe for concurrent use.
+type lockedSource struct {
+	mu  sync.Mutex  // Mutex to synchronize the source access.
+	src rand.Source // The wrapped source.
+}
+
+// Uint64 returns a pseudo-random 64-bit value as a uint64.
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
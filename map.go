@@ -1,30 +1,307 @@
 // Package xmap provides a generic, thread-safe map with automatic key expiration.
+//
+// With the default system time source, the TTLs are measured using the monotonic clock.
+// The expiration times are computed by adding the TTL to a [time.Now] reading that carries
+// a monotonic clock reading, and the expiration checks compare the monotonic clock readings,
+// so the key expiration is not affected by the wall clock changes (e.g. NTP corrections).
 package xmap
 
 import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
 	"iter"
+	"math/rand/v2"
+	"reflect"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-// entry is the value stored internally in the [Map].
-type entry[V any] struct {
+// Record is an entry stored in the [Map] [Backend].
+//
+// The fields of a Record are managed by the [Map], a [Backend] only stores the
+// records and does not need to inspect them.
+//
+// A negative record caches a loader error (See [Map.GetOrLoad]), it has a non-nil
+// err and a zero value, and it's treated as a missing key by all the methods
+// except [Map.GetOrLoad].
+type Record[V any] struct {
 	value V         // The actual value stored.
 	exp   time.Time // The expiration time of the value.
+	err   error     // The cached loader error (Negative record).
+
+	created  time.Time // The creation time of the record.
+	modified time.Time // The last modification time of the value.
+	version  uint64    // The version of the value.
+
+	meta *recordMeta[V] // The optional metadata (nil if not used).
+}
+
+// recordMeta is the optional metadata of a [Record], it's only allocated for the
+// records that use it so the records of a [Map] without these features stay small.
+type recordMeta[V any] struct {
+	// The grace period after the expiration during which the expired record is kept
+	// for [Map.GetStale], the record is removed at exp + grace (Hard deletion).
+	grace time.Duration
+	// The soft expiration time after which the live record is stale and should be
+	// refreshed (See [Map.SetRefreshAhead]), zero if the record is never stale.
+	soft time.Time
+	// The version at which the key was created, preserved when the live key is
+	// replaced (Only tracked if the diff log is enabled).
+	born  uint64
+	group uint64 // The ID of the group the record belongs to (0 for none).
+	// The version at which the expiration time was last changed without changing
+	// the value (Only tracked if the diff log is enabled).
+	touched uint64
+
+	// The last read time as nanoseconds since the map epoch (Access tracking).
+	// It's atomic since it's changed while holding the read lock.
+	accessed atomic.Int64
+
+	onExpire func(V) // Callback called with the value when the record expires.
+}
+
+// metadata returns the optional metadata of the record, allocating it if needed.
+//
+// The write lock must be held when calling this method on a stored record.
+func (r *Record[V]) metadata() *recordMeta[V] {
+	if r.meta == nil {
+		r.meta = new(recordMeta[V])
+	}
+	return r.meta
+}
+
+// grace returns the grace period of the record (See [Map.SetWithGrace]).
+func (r *Record[V]) grace() time.Duration {
+	if r.meta == nil {
+		return 0
+	}
+	return r.meta.grace
+}
+
+// soft returns the soft expiration time of the record (See [Map.SetRefreshAhead]).
+func (r *Record[V]) soft() time.Time {
+	if r.meta == nil {
+		return time.Time{}
+	}
+	return r.meta.soft
+}
+
+// born returns the version at which the key of the record was created.
+func (r *Record[V]) born() uint64 {
+	if r.meta == nil {
+		return 0
+	}
+	return r.meta.born
+}
+
+// touched returns the version at which the expiration time of the record was last
+// changed in place, 0 if it was never changed.
+func (r *Record[V]) touched() uint64 {
+	if r.meta == nil {
+		return 0
+	}
+	return r.meta.touched
+}
+
+// group returns the ID of the group of the record (0 for none).
+func (r *Record[V]) group() uint64 {
+	if r.meta == nil {
+		return 0
+	}
+	return r.meta.group
+}
+
+// onExpire returns the expiration callback of the record, nil if not set.
+func (r *Record[V]) onExpire() func(V) {
+	if r.meta == nil {
+		return nil
+	}
+	return r.meta.onExpire
+}
+
+// Value returns the value stored in the record.
+func (r *Record[V]) Value() V {
+	return r.value
+}
+
+// Expiration returns the expiration time of the record.
+//
+// A zero time value is returned if the record never expires.
+func (r *Record[V]) Expiration() time.Time {
+	return r.exp
 }
 
 // Config represents the [Map] configuration.
 type Config struct {
+	// Name is an optional label used to identify the [Map] in logs and metrics.
+	// It does not change the behavior of the [Map].
+	Name string
 	// CleanupInterval is the interval at which the expired keys are removed.
 	// Default: 5 minutes.
 	CleanupInterval time.Duration
+	// DisableAutoCleanup disables the background cleanup goroutine.
+	// The expired keys can be removed by calling [Map.RemoveExpired] or [Map.Tick]
+	// from the caller's own scheduler.
+	// Default: false.
+	DisableAutoCleanup bool
+	// FreezeCleanup pauses the removal of the expired keys while the map is frozen
+	// (See [Map.Freeze]), the expired keys are still treated as missing.
+	// Default: false.
+	FreezeCleanup bool
+	// FreezeBlocksWrites makes the methods that modify the map block while the map
+	// is frozen instead of being no-ops (See [Map.Freeze]).
+	// Default: false.
+	FreezeBlocksWrites bool
+	// OnCleanupOverrun is called by the cleanup goroutine when a sweep of the expired
+	// keys takes longer than the CleanupInterval, with the time by which the sweep
+	// exceeded the interval. The ticks missed during a long sweep are dropped, so
+	// this can be used to detect that the interval is too short for the map size.
+	// The function is called on the cleanup goroutine and must not block.
+	// Default: nil.
+	OnCleanupOverrun func(lag time.Duration)
+	// ProactiveRefresh is the window before the expiration of the keys in which the
+	// cleanup goroutine refreshes them using the function set by [Map.SetRefreshFunc],
+	// keeping the hot keys from expiring without relying on the reads.
+	// The keys are refreshed after the expired keys are removed on each cleanup
+	// interval, so the window should be larger than the CleanupInterval.
+	// Default: 0 (Disabled).
+	ProactiveRefresh time.Duration
+	// LenExcludesExpired makes [Map.Len] exclude the expired keys that have not been
+	// removed yet, at the cost of an O(n) scan instead of an O(1) operation.
+	// Default: false (Expired keys are counted).
+	LenExcludesExpired bool
+	// FreshnessThreshold is the minimum remaining TTL for a key to be reported as
+	// fresh by [Map.GetWithFreshness].
+	// Default: 0 (All the live keys are fresh).
+	FreshnessThreshold time.Duration
+	// TrackAccess enables the tracking of the last read time of the keys used by
+	// [Map.StaleKeys], at the cost of an atomic store on every read. The read time is
+	// stored in the optional metadata of the keys, so every key that is set has an
+	// additional allocation of about 80 bytes when enabled.
+	// Default: false (No memory cost).
+	TrackAccess bool
+	// MaxAge is the maximum age of the keys regardless of their expiration time.
+	// A key older than MaxAge by its creation time (See [Map.Age]) is treated as
+	// expired even if its expiration time has not been reached, this prevents the
+	// keys from being kept alive indefinitely by extending their expiration.
+	// Default: 0 (Disabled).
+	MaxAge time.Duration
+	// NegativeTTL is the duration for which the errors returned by the loader
+	// function passed to [Map.GetOrLoad] are cached.
+	// Default: 0 (Errors are not cached).
+	NegativeTTL time.Duration
+	// ShrinkOnCleanup makes the cleanup goroutine rebuild the underlying map after a
+	// cleanup pass if the fraction of the keys removed since the peak size of the
+	// map reaches the CompactThreshold, like calling [Map.Compact] on each interval.
+	// The rebuilds are bounded to one per cleanup interval, and the peak size is
+	// reset after each rebuild so the map must shrink by the same fraction again
+	// to be rebuilt, which prevents thrashing.
+	// Default: false.
+	ShrinkOnCleanup bool
+	// ServeStaleOnError makes [Map.GetOrLoad] return the last known value of an expired
+	// key instead of the error when the loader fails, trading the freshness of the
+	// value for the availability during the failures of the backing store.
+	// The expired keys are only available until they are removed by the cleanup, so
+	// the keys should be set with a grace period (See [Map.SetWithGrace]) that bounds
+	// how long a stale value can be served.
+	// Default: false.
+	ServeStaleOnError bool
+	// CompactThreshold is the minimum fraction of the keys that must have been removed
+	// since the peak size of the map for [Map.Compact] to rebuild the underlying map
+	// to reclaim memory.
+	// Default: 0.5.
+	CompactThreshold float64
+	// AutoCompactThreshold enables the automatic rebuild of the underlying map when
+	// the number of keys drops below this fraction of the peak size of the map after
+	// removing the expired keys or deleting a key (See [Map.Compact]).
+	// The peak size is reset to the current size after each rebuild, so another
+	// rebuild requires the map to shrink by the same fraction again, which prevents
+	// the repeated rebuilds of a map that stays around the same size.
+	// Default: 0 (Disabled).
+	AutoCompactThreshold float64
 	// InitialCapacity is the initial capacity hint passed to make when creating
 	// the map. It does not bound the size of the map, It will create a map with
 	// an initial space to hold the specified number of elements.
 	InitialCapacity int
+	// MonotonicClock makes the map use a time source based only on the monotonic
+	// clock, the wall clock is read once when the map is created and the current
+	// time is computed by adding the monotonic time elapsed since then.
+	// The expiration times reported (e.g. [Map.GetWithExpiration]) are wall clock
+	// times reconstructed from that base, so they are immune to the wall clock
+	// changes but drift from the wall clock by the adjustments made since then.
+	// It's ignored if a TimeSource is set.
+	// Default: false.
+	MonotonicClock bool
+	// Strict makes the map panic on misuse to surface the bugs during development:
+	//  - Passing a negative ttl to any method that accepts a ttl.
+	//  - Setting a key in a stopped map (Methods that create or replace keys).
+	//  - Storing a nil value if RejectNil is enabled (Methods that set a single key).
+	//  - Calling [Map.Run] when the cleanup goroutine is enabled or the map is stopped.
+	// The misuses are tolerated when not in strict mode, a negative ttl is treated
+	// as 0 (Never expires), and the keys set in a stopped map are never removed.
+	// Default: false.
+	Strict bool
+	// RejectNil makes the map reject the nil values of the maps whose value type is
+	// an interface type, both the nil interface values and the interface values
+	// holding a nil pointer, map, slice, function or channel (Typed nil).
+	// The rejected values are not stored and the methods that report whether the
+	// value was stored return false (e.g. [Map.Update]). If Strict is enabled, the
+	// methods that set a single key panic instead, the nil values are still skipped
+	// by the bulk methods (e.g. [Map.SetEntries]). The values are checked using
+	// reflection only if enabled and if the value type is an interface type.
+	// Default: false.
+	RejectNil bool
+	// MaxWritesPerSec is the maximum rate of the writes made using [Map.Set],
+	// [Map.Update] and [Map.TrySet], enforced by a token bucket that allows a burst
+	// of MaxWritesPerSec writes and that is refilled using the TimeSource.
+	// The excess writes are dropped by default, [Map.Set] silently ignores them,
+	// while [Map.Update] and [Map.TrySet] return false. The other methods that
+	// modify the map are not rate limited.
+	// Default: 0 (Unlimited).
+	MaxWritesPerSec int
+	// BlockRateLimited makes [Map.Set] and [Map.Update] wait until the write is
+	// allowed by the MaxWritesPerSec rate limit instead of dropping it, the wait
+	// ends when the map is stopped. [Map.TrySet] never blocks.
+	// Default: false.
+	BlockRateLimited bool
+	// PauseExpiration makes the map treat the expired keys as live while the cleanup
+	// is paused (See [Map.PauseCleanup]), so the keys neither expire for the reads
+	// nor get removed until the cleanup is resumed.
+	// Default: false (Only the cleanup goroutine is paused).
+	PauseExpiration bool
+	// DiffLogSize is the maximum number of the key removals recorded to report the
+	// changes made since a version using [Map.DiffSince], the oldest removals are
+	// dropped when the log is full. Enabling the log also tracks the creation
+	// version of the keys, at the cost of an additional lookup on every write.
+	// Default: 0 (Disabled, [Map.DiffSince] always reports the changes as unknown).
+	DiffLogSize int
+	// TTLJitter is the maximum random duration added to or subtracted from the
+	// positive ttl of every key that is set, so the keys set at the same time with
+	// the same ttl do not expire at the same time. The jittered ttl is at least 1ns,
+	// and a ttl of 0 (Never expires) is not affected.
+	// Default: 0 (Disabled).
+	TTLJitter time.Duration
+	// Rand is the source of the random numbers used for the TTLJitter, which is only
+	// useful for deterministic tests, it's used while holding an internal lock so
+	// it does not need to be safe for concurrent use (The shards of a [Sharded]
+	// map share the same source).
+	// Default: the global [rand] functions.
+	Rand rand.Source
+	// LockMode is the locking strategy used to synchronize the map access.
+	// Default: LockWritePreferring ([sync.RWMutex]).
+	LockMode LockMode
+	// TrackLockStats enables the lock contention counters returned by [Map.LockStats].
+	// When disabled the lock is not wrapped, so there is no overhead.
+	// Default: false.
+	TrackLockStats bool
 	// TimeSource is the time source used by the map for key expiration.
 	// This is only useful for testing.
+	// The returned times should carry a monotonic clock reading for the key
+	// expiration to be immune to wall clock changes.
 	// Default: system time.
 	TimeSource Time
 }
@@ -35,20 +312,93 @@ func (c *Config) setDefaults() {
 		c.CleanupInterval = 5 * time.Minute
 	}
 
+	if c.CompactThreshold == 0 {
+		c.CompactThreshold = 0.5
+	}
+
+	if c.Rand != nil {
+		if _, ok := c.Rand.(*lockedSource); !ok {
+			c.Rand = &lockedSource{src: c.Rand}
+		}
+	}
+
 	if c.TimeSource == nil {
-		c.TimeSource = &systemTime{}
+		if c.MonotonicClock {
+			c.TimeSource = newMonotonicTime()
+		} else {
+			c.TimeSource = &systemTime{}
+		}
 	}
 }
 
 // Map is a thread-safe map with automatic key expiration.
 type Map[K comparable, V any] struct {
-	mu       sync.RWMutex    // Mutex to synchronize the map access.
-	kv       map[K]*entry[V] // The underlying map.
-	interval time.Duration   // Cleanup interval.
-	time     Time            // Time source.
-	stop     chan struct{}   // Channel closed on stop.
-	active   atomic.Int32    // Cleanup active flag.
-	stopped  atomic.Int32    // Map stopped flag.
+	mu       rwLocker            // Mutex to synchronize the map access.
+	kv       Backend[K, V]       // The underlying storage.
+	name     string              // The map name.
+	interval time.Duration       // Cleanup interval.
+	negTTL   time.Duration       // Loader errors TTL.
+	stale    bool                // Serve stale values on loader errors.
+	maxAge   time.Duration       // Maximum age of the keys.
+	compact  float64             // Compact threshold.
+	capacity int                 // Initial capacity.
+	jitter   time.Duration       // TTL jitter.
+	autoCmp  float64             // Auto compact threshold.
+	shrinkCl bool                // Shrink after the cleanup passes.
+	liveLen  bool                // Exclude expired keys from Len.
+	strict   bool                // Panic on misuse.
+	rejNil   bool                // Reject the nil interface values.
+	fresh    time.Duration       // Freshness threshold.
+	tracking bool                // Access tracking enabled.
+	epoch    time.Time           // The map creation time (Access tracking).
+	auto     bool                // Cleanup goroutine enabled.
+	overrun  func(time.Duration) // Cleanup overrun hook.
+	window   time.Duration       // Proactive refresh window.
+	frzClean bool                // Pause the cleanup while frozen.
+	time     Time                // Time source.
+	stop     chan struct{}       // Channel closed on stop.
+	active   atomic.Int32        // Cleanup active flag.
+	stopped  atomic.Int32        // Map stopped flag.
+
+	groups  map[K]*group[K] // Key groups index.
+	groupID uint64          // Last assigned group ID.
+
+	version uint64 // Last assigned version number.
+
+	// In-flight calls, each API has its own group so the callers only share the
+	// results of the same kind of function.
+	inits    flight[K, V] // In-flight calls of [Map.Once].
+	computes flight[K, V] // In-flight calls of [Map.GetOrCompute].
+	loads    flight[K, V] // In-flight loads of [Map.GetOrLoad] and [Map.GetManyOrLoad].
+
+	watchers  map[K][]chan struct{} // Key removal watchers.
+	refresh   RefreshFunc[K, V]     // Proactive refresh function.
+	onReplace ReplaceFunc[K, V]     // Live key replacement hook.
+	spill     *Map[K, V]            // Spill map of the trimmed keys.
+	fallback  *Map[K, V]            // Spill map used by the reads (nil if disabled).
+	frozen    bool                  // Read-only state.
+	blockFrz  bool                  // Block the writes while frozen.
+	thaw      *sync.Cond            // Condition signaled when unfrozen or stopped.
+
+	rng *rand.Rand // Random numbers generator (nil for the global functions).
+
+	locks *lockCounters // Lock contention counters (nil if disabled).
+
+	limiter   *tokenBucket // Writes rate limiter (nil if disabled).
+	blockRate bool         // Wait for the rate limiter instead of dropping the writes.
+
+	paused   atomic.Bool // Cleanup paused flag.
+	pauseExp bool        // Pause the expiration while the cleanup is paused.
+
+	diffLog   int            // Maximum number of removals in the diff log (0 if disabled).
+	tombs     []tombstone[K] // Diff log of the last removals.
+	diffFloor uint64         // Oldest version since which the changes are known.
+
+	count       atomic.Int64              // Number of records (Lock-free length).
+	lastCleanup atomic.Pointer[time.Time] // Time of the last cleanup pass.
+
+	hits   atomic.Uint64 // Number of lookups of live keys.
+	misses atomic.Uint64 // Number of lookups of missing keys.
 }
 
 // New creates a new [Map] instance with the default configuration.
@@ -58,23 +408,105 @@ func New[K comparable, V any]() *Map[K, V] {
 
 // NewWithConfig creates a new [Map] instance with the specified configuration.
 func NewWithConfig[K comparable, V any](cfg Config) *Map[K, V] {
+	return NewWithBackend(cfg, newMemoryBackend[K, V](cfg.InitialCapacity))
+}
+
+// NewWithBackend creates a new [Map] instance with the specified configuration
+// that stores the entries in the specified [Backend].
+//
+// The key expiration and the synchronization are handled by the [Map], the
+// [Config.InitialCapacity] is not used since it only applies to the default
+// in-memory backend. The backend must be an in-process store (See [Backend]).
+func NewWithBackend[K comparable, V any](cfg Config, backend Backend[K, V]) *Map[K, V] {
+	m := newMap(cfg, backend)
+	m.start()
+	return m
+}
+
+// NewWithEntries creates a new [Map] instance with the specified configuration
+// seeded with the entries, all the entries are set with the same ttl.
+//
+// The entries are inserted before the cleanup goroutine is started, and their
+// expiration times are computed from the construction time. A ttl value of 0
+// means that the entries never expire.
+//
+// The entries are a one-time seed, the map is not a fallback source of the
+// missing or expired keys, and it's not retained by the [Map].
+func NewWithEntries[K comparable, V any](cfg Config, entries map[K]V, ttl time.Duration) *Map[K, V] {
+	m := newMap(cfg, newMemoryBackend[K, V](max(cfg.InitialCapacity, len(entries))))
+	m.SetFromMap(entries, ttl)
+	m.start()
+	return m
+}
+
+// newMap creates a new [Map] instance without starting the cleanup goroutine.
+func newMap[K comparable, V any](cfg Config, backend Backend[K, V]) *Map[K, V] {
 	cfg.setDefaults()
 
 	m := &Map[K, V]{
-		kv:       make(map[K]*entry[V], cfg.InitialCapacity),
+		mu:       newLocker(cfg.LockMode),
+		kv:       backend,
+		name:     cfg.Name,
 		stop:     make(chan struct{}),
 		interval: cfg.CleanupInterval,
+		negTTL:   cfg.NegativeTTL,
+		stale:    cfg.ServeStaleOnError,
+		maxAge:   cfg.MaxAge,
+		compact:  cfg.CompactThreshold,
+		capacity: cfg.InitialCapacity,
+		jitter:   cfg.TTLJitter,
+		autoCmp:  cfg.AutoCompactThreshold,
+		shrinkCl: cfg.ShrinkOnCleanup,
+		liveLen:  cfg.LenExcludesExpired,
+		strict:   cfg.Strict,
+		rejNil:   cfg.RejectNil && reflect.TypeFor[V]().Kind() == reflect.Interface,
+		fresh:    cfg.FreshnessThreshold,
+		tracking: cfg.TrackAccess,
+		epoch:    cfg.TimeSource.Now(),
+		auto:     !cfg.DisableAutoCleanup,
+		overrun:  cfg.OnCleanupOverrun,
+		window:   cfg.ProactiveRefresh,
+		frzClean: cfg.FreezeCleanup,
 		time:     cfg.TimeSource,
+		blockFrz: cfg.FreezeBlocksWrites,
 	}
-
-	go m.cleanup()
+	if cfg.TrackLockStats {
+		m.locks = &lockCounters{}
+		m.mu = &countingLocker{m.mu, m.locks}
+	}
+	m.thaw = sync.NewCond(m.mu)
+	if cfg.MaxWritesPerSec > 0 {
+		m.limiter = newTokenBucket(cfg.MaxWritesPerSec, cfg.TimeSource.Now())
+	}
+	m.blockRate = cfg.BlockRateLimited
+	m.diffLog = max(cfg.DiffLogSize, 0)
+	m.pauseExp = cfg.PauseExpiration
+	if cfg.Rand != nil {
+		m.rng = rand.New(cfg.Rand)
+	}
+	m.count.Store(int64(backend.Len()))
 
 	return m
 }
 
+// start starts the cleanup goroutine if it's enabled.
+func (m *Map[K, V]) start() {
+	if m.auto {
+		go m.cleanup()
+	}
+}
+
+// Name returns the name of the [Map] set in the [Config].
+func (m *Map[K, V]) Name() string {
+	return m.name
+}
+
 // Stop halts the background cleanup goroutine and clears the [Map].
 // It should be called when the [Map] is no longer needed.
 //
+// If [Config.DisableAutoCleanup] is set, there is no goroutine to stop and
+// the [Map] is only cleared.
+//
 // This method is safe to be called multiple times.
 //
 // A stopped [Map] should not be re-used, a new [Map] should be created instead.
@@ -85,11 +517,60 @@ func (m *Map[K, V]) Stop() {
 
 		// Clear the map to free up resources.
 		m.mu.Lock()
-		m.kv = make(map[K]*entry[V])
+		m.release()
 		m.mu.Unlock()
 	}
 }
 
+// DrainAndStop stops the [Map] like [Map.Stop] and calls fn for each key that has
+// not expired before clearing the [Map].
+//
+// The live entries are collected and the [Map] is cleared while holding the write
+// lock, then fn is called without holding any lock, so each entry is delivered
+// exactly once. The callers should stop writing to the [Map] before calling this
+// method, the writes that happen after the entries are collected are not delivered.
+//
+// The function fn is not called if the [Map] is already stopped.
+func (m *Map[K, V]) DrainAndStop(fn func(K, V)) {
+	if !m.stopped.CompareAndSwap(0, 1) {
+		return
+	}
+
+	// Stop the cleanup goroutine.
+	close(m.stop)
+
+	var entries []Entry[K, V]
+
+	m.mu.Lock()
+	for key, entry := range m.kv.Range {
+		if m.live(entry) {
+			entries = append(entries, Entry[K, V]{key, entry.value, entry.exp})
+		}
+	}
+	m.release()
+	m.mu.Unlock()
+
+	for _, e := range entries {
+		fn(e.Key, e.Value)
+	}
+}
+
+// release clears the [Map] and releases the memory used by the internal structures,
+// and wakes up the writers waiting for the [Map] to be unfrozen.
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) release() {
+	m.kv.Clear()
+	m.count.Store(0)
+	m.shrink(0)
+	if m.diffLog > 0 {
+		m.trackClear()
+	}
+	m.groups = nil
+	m.notifyAll()
+	m.thaw.Broadcast()
+}
+
 // Stopped reports whether the [Map] is stopped.
 //
 // Expired keys are not removed automatically in a stopped [Map].
@@ -100,162 +581,2224 @@ func (m *Map[K, V]) Stopped() bool {
 // Len returns the length of the [Map].
 //
 // The length of the [Map] is the total number of keys, including the expired
-// keys that have not been removed yet.
+// keys that have not been removed yet and the cached loader errors.
 //
 // To get the length excluding the number of expired keys, call [Map.RemoveExpired]
-// before calling this method.
+// before calling this method, or set [Config.LenExcludesExpired] to only count the
+// live keys, which changes the cost of this method from O(1) to O(n).
 func (m *Map[K, V]) Len() int {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return len(m.kv)
+
+	if !m.liveLen {
+		return m.kv.Len()
+	}
+
+	var n int
+	for _, entry := range m.kv.Range {
+		if m.live(entry) {
+			n++
+		}
+	}
+	return n
+}
+
+// ApproxLen returns the length of the [Map] without acquiring any lock.
+//
+// Like [Map.Len], the length includes the expired keys that have not been removed
+// yet. The length is stored in an atomic counter that is set to the number of
+// records of the [Backend] after each change made while holding the write lock, so
+// a new key increments it, replacing a key does not change it, and removing a key
+// (Deleted or expired) decrements it. The returned length might not reflect the
+// changes that are in progress in other goroutines.
+func (m *Map[K, V]) ApproxLen() int {
+	return int(m.count.Load())
 }
 
 // Set creates or replaces a key-value pair in the [Map].
 //
 // A key can be set to never expire with a ttl value of 0.
+//
+// The creation time of the key is reset (See [Map.Age]), and the value stored
+// for a live key can be changed by the replace function (See [Map.SetOnReplace]).
+// The write is dropped or delayed if it's rate limited (See [Config.MaxWritesPerSec]),
+// the ttl of a delayed write starts when the write is allowed.
 func (m *Map[K, V]) Set(key K, value V, ttl time.Duration) {
-	var exp time.Time
-
-	if ttl > 0 {
-		exp = m.time.Now().Add(ttl)
+	// Wait before creating the record so the wait is not taken out of the ttl.
+	if !m.allowWrite(true) {
+		return
 	}
 
+	entry := m.newRecord(value, ttl)
+
 	m.mu.Lock()
-	m.kv[key] = &entry[V]{value, exp}
+	if m.writable() {
+		m.merge(key, entry)
+		m.store(key, entry)
+	}
 	m.mu.Unlock()
 }
 
-// Update changes the value of the key while preserving the expiration time.
+// SetR sets the key like [Map.Set] and reports whether a live value was replaced.
 //
-// The return value reports whether there was an update (Key exists).
-func (m *Map[K, V]) Update(key K, value V) bool {
+// The return value is false if the key was missing, expired or a cached loader
+// error, in which case the key is considered as inserted.
+func (m *Map[K, V]) SetR(key K, value V, ttl time.Duration) (replaced bool) {
+	entry := m.newRecord(value, ttl)
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if entry, ok := m.kv[key]; ok && !m.expired(entry) {
-		entry.value = value
-		return true
+	if !m.writable() {
+		return false
 	}
-	return false
+
+	old, exists := m.kv.Get(key)
+	live := exists && m.live(old)
+
+	m.merge(key, entry)
+	return m.store(key, entry) && live
 }
 
-// Get returns the value associated with the key.
+// SetReturning sets the key like [Map.Set] and returns the previous value and
+// expiration time of the key in a single write lock. The stored value can be
+// changed by the replace function (See [Map.SetOnReplace]), the previous value
+// is returned as it was before the merge.
 //
-// The second bool return value reports whether the key exists in the [Map].
-func (m *Map[K, V]) Get(key K) (V, bool) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// The existed return value is false if the key was missing, expired or a cached
+// loader error, in which case the zero values are returned. A zero previous
+// expiration time means that the previous value never expired.
+func (m *Map[K, V]) SetReturning(key K, value V, ttl time.Duration) (prevValue V, prevExp time.Time, existed bool) {
+	entry := m.newRecord(value, ttl)
 
-	if entry, ok := m.kv[key]; ok && !m.expired(entry) {
-		return entry.value, true
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return prevValue, prevExp, false
 	}
 
-	var zero V
-	return zero, false
+	if old, ok := m.kv.Get(key); ok && m.live(old) {
+		prevValue, prevExp, existed = old.value, old.exp, true
+	}
+
+	m.merge(key, entry)
+	m.store(key, entry)
+	return prevValue, prevExp, existed
 }
 
-// GetWithExpiration returns the value and expiration time of the key.
+// SetManyFunc creates or replaces multiple key-value pairs in the [Map] under a
+// single write lock, the ttl of each key is returned by the function ttlFor.
 //
-// The third bool return value reports whether the key exists in the [Map].
-func (m *Map[K, V]) GetWithExpiration(key K) (V, time.Time, bool) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// The expiration times are computed from a single reading of the current time.
+// A ttl value of 0 means that the key never expires.
+//
+// The function ttlFor is called for all the keys before acquiring the lock.
+func (m *Map[K, V]) SetManyFunc(values map[K]V, ttlFor func(K, V) time.Duration) {
+	m.checkWrite(0)
 
-	if entry, ok := m.kv[key]; ok && !m.expired(entry) {
-		return entry.value, entry.exp, true
+	now := m.time.Now()
+
+	entries := make(map[K]*Record[V], len(values))
+	for key, value := range values {
+		entry := &Record[V]{value: value, created: now, modified: now}
+		ttl := ttlFor(key, value)
+		m.checkWrite(ttl)
+		entry.exp = m.expiration(now, ttl)
+		entries[key] = entry
 	}
 
-	var zero V
-	return zero, time.Time{}, false
+	m.mu.Lock()
+	for key, entry := range entries {
+		m.store(key, entry)
+	}
+	m.mu.Unlock()
 }
 
-// All returns an iterator over key-value pairs from the [Map].
+// SetFromMap creates or replaces the key-value pairs of the map src in the [Map]
+// under a single write lock, all the keys are set with the same ttl.
 //
-// Only the entries that have not expired are produced during the iteration.
+// The existing keys are overwritten, and a ttl value of 0 means that the keys
+// never expire.
+func (m *Map[K, V]) SetFromMap(src map[K]V, ttl time.Duration) {
+	m.checkWrite(ttl)
+
+	now := m.time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, value := range src {
+		exp := m.expiration(now, ttl)
+		m.store(key, &Record[V]{value: value, exp: exp, created: now, modified: now})
+	}
+}
+
+// setSeqBatchSize is the number of pairs set under a single write lock by [Map.SetSeq].
+const setSeqBatchSize = 1024
+
+// SetSeq creates or replaces the key-value pairs produced by the iterator seq in
+// the [Map], all the keys are set with the same ttl.
 //
-// Similar to the map type, the iteration order is not guaranteed.
-func (m *Map[K, V]) All() iter.Seq2[K, V] {
-	return func(yield func(K, V) bool) {
-		m.mu.RLock()
-		defer m.mu.RUnlock()
+// The pairs are consumed without holding the lock and they are set in batches of
+// up to 1024 pairs, each batch under a single write lock, so the whole dataset is
+// never materialized and the lock is released between the batches. The other
+// goroutines might observe a partially loaded sequence, and the expiration times
+// are computed from the time each batch is set.
+// A ttl value of 0 means that the keys never expire.
+//
+// It returns the number of keys that were set.
+func (m *Map[K, V]) SetSeq(seq iter.Seq2[K, V], ttl time.Duration) int {
+	m.checkWrite(ttl)
 
-		for key, entry := range m.kv {
-			if !m.expired(entry) {
-				if !yield(key, entry.value) {
-					return
-				}
+	type pair struct {
+		key   K
+		value V
+	}
+
+	batch := make([]pair, 0, setSeqBatchSize)
+	var n int
+
+	flush := func() {
+		now := m.time.Now()
+
+		m.mu.Lock()
+		for _, p := range batch {
+			exp := m.expiration(now, ttl)
+			if m.store(p.key, &Record[V]{value: p.value, exp: exp, created: now, modified: now}) {
+				n++
 			}
 		}
+		m.mu.Unlock()
+
+		batch = batch[:0]
+	}
+
+	for key, value := range seq {
+		batch = append(batch, pair{key, value})
+		if len(batch) == setSeqBatchSize {
+			flush()
+		}
+	}
+
+	if len(batch) > 0 {
+		flush()
 	}
+
+	return n
 }
 
-// Delete removes a key from the [Map].
-func (m *Map[K, V]) Delete(key K) {
+// SetEntries creates or replaces multiple key-value pairs in the [Map] under a
+// single write lock, each key expires at the absolute time of [Entry.Expiration].
+//
+// The entries with a zero expiration time never expire, and the entries whose
+// expiration time has already passed are skipped.
+func (m *Map[K, V]) SetEntries(entries []Entry[K, V]) {
+	m.SetManyR(entries)
+}
+
+// SetManyR sets the entries like [Map.SetEntries] under a single write lock and
+// reports the number of the inserted keys and the number of the replaced keys.
+//
+// A key is counted as replaced only if it had a live value, the keys that were
+// missing, expired or cached loader errors are counted as inserted.
+// The skipped entries are not counted.
+func (m *Map[K, V]) SetManyR(entries []Entry[K, V]) (inserted, replaced int) {
+	m.checkWrite(0)
+
+	now := m.time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range entries {
+		if !e.Expiration.IsZero() && now.After(e.Expiration) {
+			continue
+		}
+
+		old, exists := m.kv.Get(e.Key)
+		live := exists && m.live(old)
+
+		stored := m.store(e.Key, &Record[V]{
+			value:    e.Value,
+			exp:      e.Expiration,
+			created:  now,
+			modified: now,
+		})
+
+		switch {
+		case !stored:
+		case live:
+			replaced++
+		default:
+			inserted++
+		}
+	}
+
+	return inserted, replaced
+}
+
+// SetWithCallback creates or replaces a key-value pair in the [Map] with a callback
+// that is called with the key and the value when the key expires.
+//
+// The callback is called when the expired key is removed from the [Map], either by the
+// cleanup goroutine or by [Map.RemoveExpired], or when the key is evicted by [Map.Trim],
+// it runs without holding the lock so it's safe to call the [Map] methods from the callback.
+//
+// The callback is cancelled if the key is replaced or deleted before it expires.
+// [Map.Update] changes the value passed to the callback.
+func (m *Map[K, V]) SetWithCallback(key K, value V, ttl time.Duration, onExpire func(K, V)) {
+	entry := m.newRecord(value, ttl)
+	entry.metadata().onExpire = func(v V) { onExpire(key, v) }
+
 	m.mu.Lock()
-	delete(m.kv, key)
+	m.store(key, entry)
 	m.mu.Unlock()
 }
 
-// Clear removes all the entries from the [Map].
-func (m *Map[K, V]) Clear() {
+// SetWithGrace creates or replaces a key-value pair in the [Map] that is kept for
+// a grace period after its expiration.
+//
+// The key expires after the ttl like [Map.Set], it's treated as missing by all the
+// methods except [Map.GetStale] that returns it until the end of the grace period.
+// The expired key is only removed by the cleanup after the grace period, the
+// record keeps its expiration time and the grace period, so the hard deletion
+// time is the expiration time plus the grace period and it moves with the
+// expiration time (e.g. [Map.GetAndTouchIfBelow]).
+//
+// A ttl value of 0 makes the key never expire, in which case the grace period
+// is not used.
+func (m *Map[K, V]) SetWithGrace(key K, value V, ttl, grace time.Duration) {
+	m.checkTTL(grace)
+
+	entry := m.newRecord(value, ttl)
+	entry.metadata().grace = max(grace, 0)
+
 	m.mu.Lock()
-	clear(m.kv)
+	m.store(key, entry)
 	m.mu.Unlock()
 }
 
-// cleanup removes expired keys from the [Map] in an interval.
+// SetUntil creates or replaces a key-value pair in the [Map] that expires at the
+// absolute deadline and that is kept for a grace period after the deadline.
 //
-// The cleanup is stopped by calling [Map.Stop].
-func (m *Map[K, V]) cleanup() {
-	ticker := m.time.NewTicker(m.interval)
-	defer ticker.Stop()
+// Similar to [Map.SetWithGrace], the key is treated as missing by the reads after
+// the deadline, except by [Map.GetStale] that returns it until deadline + grace,
+// and the expired key is only removed by the cleanup after deadline + grace.
+// The expiration is checked lazily by the reads, so the key is never served as
+// fresh after the deadline even if the cleanup has not run.
+//
+// A zero deadline makes the key never expire. The key is not set if deadline + grace
+// has already passed. The [Config.TTLJitter] is not applied to the deadline.
+func (m *Map[K, V]) SetUntil(key K, value V, deadline time.Time, grace time.Duration) {
+	m.checkWrite(grace)
+	m.checkValue(value)
 
-	// Set as active.
-	m.active.Store(1)
-	defer m.active.Store(0)
+	now := m.time.Now()
+	if !deadline.IsZero() && now.After(deadline.Add(max(grace, 0))) {
+		return
+	}
 
-	for {
-		select {
-		case <-m.stop:
-			return
-		case <-ticker.C():
-			m.RemoveExpired()
-		}
+	entry := &Record[V]{value: value, exp: deadline, created: now, modified: now}
+	if grace > 0 {
+		entry.metadata().grace = grace
 	}
+
+	m.mu.Lock()
+	m.store(key, entry)
+	m.mu.Unlock()
 }
 
-// CleanupActive reports whether the cleanup goroutine is active.
-func (m *Map[K, V]) CleanupActive() bool {
-	return m.active.Load() == 1
+// SetAtUnix creates or replaces a key-value pair in the [Map] that expires at the
+// absolute time unixSeconds, specified as the number of seconds since the Unix epoch.
+//
+// A zero or negative unixSeconds value means that the key never expires, and the
+// key is not set if the expiration time has already passed. Like [Map.SetUntil],
+// the [Config.TTLJitter] is not applied to the expiration time.
+func (m *Map[K, V]) SetAtUnix(key K, value V, unixSeconds int64) {
+	var deadline time.Time
+	if unixSeconds > 0 {
+		deadline = time.Unix(unixSeconds, 0)
+	}
+
+	m.SetUntil(key, value, deadline, 0)
 }
 
-// RemoveExpired checks the [Map] keys and removes the expired ones.
+// Update changes the value of the key while preserving the expiration time.
 //
-// It returns the number of keys that were removed.
-func (m *Map[K, V]) RemoveExpired() int {
-	// Expired keys.
-	var expired []K
+// The creation time of the key is preserved (See [Map.Age]).
+//
+// The return value reports whether there was an update (Key exists).
+func (m *Map[K, V]) Update(key K, value V) bool {
+	m.checkValue(value)
 
-	// Find the expired keys.
-	m.mu.RLock()
-	for key, entry := range m.kv {
-		if m.expired(entry) {
-			expired = append(expired, key)
-		}
+	if !m.allowWrite(true) {
+		return false
 	}
-	m.mu.RUnlock()
 
-	// Remove the expired keys.
+	now := m.time.Now()
+
 	m.mu.Lock()
-	for _, key := range expired {
-		delete(m.kv, key)
+	defer m.mu.Unlock()
+
+	if !m.writable() || m.rejected(value) {
+		return false
 	}
-	m.mu.Unlock()
 
-	return len(expired)
+	if entry, ok := m.kv.Get(key); ok && m.live(entry) {
+		entry.value = value
+		entry.modified = now
+		entry.version = m.nextVersion()
+		return true
+	}
+	return false
+}
+
+// ReplaceAndGet replaces the value and the expiration time of the key only if
+// the key exists, and returns the previous value.
+//
+// A key can be set to never expire with a ttl value of 0.
+//
+// The second bool return value reports whether the key was replaced (Key exists),
+// if the key does not exist the [Map] is not changed and the zero value is returned.
+func (m *Map[K, V]) ReplaceAndGet(key K, value V, ttl time.Duration) (V, bool) {
+	replacement := m.newRecord(value, ttl)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var zero V
+	if !m.writable() || m.rejected(value) {
+		return zero, false
+	}
+
+	if entry, ok := m.kv.Get(key); ok && m.live(entry) {
+		old := entry.value
+		entry.value = value
+		entry.exp = replacement.exp
+		if entry.meta != nil || replacement.meta != nil {
+			entry.metadata().grace = replacement.grace()
+			entry.metadata().soft = replacement.soft()
+		}
+		entry.created = replacement.created
+		entry.modified = replacement.modified
+		entry.version = m.nextVersion()
+		return old, true
+	}
+
+	return zero, false
+}
+
+// Get returns the value associated with the key.
+//
+// The second bool return value reports whether the key exists in the [Map].
+// If the read fallback of the spill map is enabled (See [Map.SetSpillTo]), the
+// missing keys are looked up in the spill map.
+func (m *Map[K, V]) Get(key K) (V, bool) {
+	m.mu.RLock()
+	if entry, ok := m.lookup(key); ok {
+		value := entry.value
+		m.mu.RUnlock()
+		return value, true
+	}
+	spill := m.fallback
+	m.mu.RUnlock()
+
+	if spill != nil {
+		return spill.getLocal(key)
+	}
+
+	var zero V
+	return zero, false
+}
+
+// getLocal returns the value of the key like [Map.Get] without the spill map fallback.
+func (m *Map[K, V]) getLocal(key K) (V, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if entry, ok := m.lookup(key); ok {
+		return entry.value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// MustGet returns the value associated with the key, it panics if the key does
+// not exist in the [Map].
+//
+// It's meant for the call sites where the key is known to exist.
+func (m *Map[K, V]) MustGet(key K) V {
+	value, ok := m.Get(key)
+	if !ok {
+		panic(fmt.Sprintf("xmap: key %v not found", key))
+	}
+	return value
+}
+
+// GetPtr returns a pointer to a copy of the value associated with the key, or nil
+// if the key does not exist in the [Map].
+//
+// Modifying the pointed value does not change the value stored in the [Map].
+func (m *Map[K, V]) GetPtr(key K) *V {
+	value, ok := m.Get(key)
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+// GetWithExpiration returns the value and expiration time of the key.
+//
+// The third bool return value reports whether the key exists in the [Map].
+func (m *Map[K, V]) GetWithExpiration(key K) (V, time.Time, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if entry, ok := m.lookup(key); ok {
+		return entry.value, entry.exp, true
+	}
+
+	var zero V
+	return zero, time.Time{}, false
+}
+
+// GetEntries returns the entries of the specified keys in a single read locked pass.
+//
+// The entries are returned in the order of the keys, the missing and the expired
+// keys are omitted.
+func (m *Map[K, V]) GetEntries(keys []K) []Entry[K, V] {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make([]Entry[K, V], 0, len(keys))
+	for _, key := range keys {
+		if entry, ok := m.lookup(key); ok {
+			entries = append(entries, Entry[K, V]{key, entry.value, entry.exp})
+		}
+	}
+	return entries
+}
+
+// GetWithFreshness returns the value associated with the key and reports whether
+// the key is fresh, which is when its remaining TTL exceeds [Config.FreshnessThreshold].
+//
+// The keys that never expire are always fresh. This allows the callers to refresh
+// the keys that are close to their expiration without computing the remaining TTL.
+//
+// The third bool return value reports whether the key exists in the [Map], the
+// expired keys are not returned.
+func (m *Map[K, V]) GetWithFreshness(key K) (value V, fresh bool, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if entry, ok := m.lookup(key); ok {
+		fresh := entry.exp.IsZero() || entry.exp.Sub(m.time.Now()) > m.fresh
+		return entry.value, fresh, true
+	}
+
+	return value, false, false
+}
+
+// GetAndTouchIfBelow returns the value associated with the key and resets its
+// expiration time to the current time plus ttl only if its remaining TTL is below
+// the threshold.
+//
+// This allows a sliding expiration without acquiring the write lock on every read,
+// the write lock is only acquired when the key is close to its expiration.
+// A ttl value of 0 makes the key never expire when it's touched, the keys that
+// never expire are not touched.
+//
+// The second bool return value reports whether the key exists in the [Map].
+func (m *Map[K, V]) GetAndTouchIfBelow(key K, threshold, ttl time.Duration) (V, bool) {
+	m.checkTTL(ttl)
+
+	m.mu.RLock()
+	entry, ok := m.lookup(key)
+	if !ok {
+		m.mu.RUnlock()
+		var zero V
+		return zero, false
+	}
+
+	now := m.time.Now()
+	if entry.exp.IsZero() || entry.exp.Sub(now) >= threshold {
+		m.mu.RUnlock()
+		return entry.value, true
+	}
+	m.mu.RUnlock()
+
+	var exp time.Time
+
+	if ttl > 0 {
+		exp = now.Add(ttl)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The key might have been changed after releasing the read lock.
+	if entry, ok := m.kv.Get(key); ok && m.live(entry) {
+		if !entry.exp.IsZero() && entry.exp.Sub(now) < threshold && !m.frozen {
+			entry.exp = exp
+			m.trackTouch(entry)
+		}
+		return entry.value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// SetRefreshAhead creates or replaces a key-value pair in the [Map] that becomes
+// stale after the soft ttl and that expires after the hard ttl.
+//
+// The record keeps two timestamps: the soft expiration time, only reported by
+// [Map.GetRefreshAhead] to let the callers refresh the value before it expires,
+// and the hard expiration time that is the regular expiration time of the key,
+// after which the key is treated as missing and removed by the cleanup.
+// A stale key is live for all the other methods until its hard expiration.
+//
+// Unlike [Map.SetWithGrace] that serves the values after their expiration, the
+// stale values are served before the expiration so the key never goes missing
+// if it's refreshed in time.
+//
+// A hard ttl value of 0 makes the key never expire, and a soft ttl value of 0
+// makes the key never stale. The key is replaced by the other methods that set
+// it (Including the refresh), which clear the soft expiration time, while the
+// methods that update the value in place (e.g. [Map.Update]) preserve it.
+func (m *Map[K, V]) SetRefreshAhead(key K, value V, soft, hard time.Duration) {
+	m.checkTTL(soft)
+
+	entry := m.newRecord(value, hard)
+	if soft > 0 {
+		entry.metadata().soft = entry.created.Add(soft)
+	}
+
+	m.mu.Lock()
+	m.store(key, entry)
+	m.mu.Unlock()
+}
+
+// GetRefreshAhead returns the value associated with the key and reports whether
+// it's stale, the key is stale if its soft expiration time has passed (See
+// [Map.SetRefreshAhead]).
+//
+// The third bool return value reports whether the key exists in the [Map], the
+// expired keys are missing even if they are stale.
+func (m *Map[K, V]) GetRefreshAhead(key K) (v V, stale bool, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.lookup(key)
+	if !ok {
+		return v, false, false
+	}
+
+	stale = !entry.soft().IsZero() && m.time.Now().After(entry.soft())
+	return entry.value, stale, true
+}
+
+// GetStale returns the value associated with the key including the expired keys
+// within their grace period (See [Map.SetWithGrace]).
+//
+// The second bool return value reports whether the key has expired and it's served
+// during its grace period, which allows the callers to revalidate the stale values.
+// The third bool return value reports whether the key exists in the [Map].
+func (m *Map[K, V]) GetStale(key K) (value V, stale bool, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.time.Now()
+
+	entry, ok := m.kv.Get(key)
+	if !ok || entry.err != nil || m.removableAt(entry, now) {
+		m.misses.Add(1)
+		return value, false, false
+	}
+
+	m.markAccess(entry)
+	m.hits.Add(1)
+	return entry.value, m.expiredAt(entry, now), true
+}
+
+// IsExpired reports whether the key has expired but has not been removed yet.
+//
+// Unlike [Map.Get] which treats the expired keys as missing, the second bool return
+// value reports whether the key exists in the underlying storage regardless of its
+// expiration, so the expired keys waiting for removal can be detected.
+// The key is not removed by this method.
+func (m *Map[K, V]) IsExpired(key K) (expired bool, exists bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.kv.Get(key)
+	if !ok {
+		return false, false
+	}
+	return m.expired(entry), true
+}
+
+// GetOrSet returns the value of the key if it exists, otherwise it sets the key
+// to the specified value and ttl and returns it.
+//
+// The second bool return value reports whether the key already existed, the zero
+// value is returned with false if the key is missing and it cannot be set (e.g. the
+// [Map] is frozen, See [Map.Freeze]).
+func (m *Map[K, V]) GetOrSet(key K, value V, ttl time.Duration) (V, bool) {
+	value, _, ok := m.GetOrSetWithExpiration(key, value, ttl)
+	return value, ok
+}
+
+// GetOrSetWithExpiration returns the value and the expiration time of the key if it
+// exists, otherwise it sets the key to the specified value and ttl and returns
+// the value with the expiration time.
+//
+// The expiration time of a new key is the current time plus the ttl, or a zero time
+// value if the ttl is 0 (Never expires).
+//
+// The third bool return value reports whether the key already existed, the zero
+// values are returned with false if the key is missing and it cannot be set (e.g.
+// the [Map] is frozen, See [Map.Freeze]).
+func (m *Map[K, V]) GetOrSetWithExpiration(key K, value V, ttl time.Duration) (V, time.Time, bool) {
+	entry := m.newRecord(value, ttl)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Wait before the lookup if the writes are blocked (See [Map.Freeze]).
+	m.writable()
+
+	if current, ok := m.lookup(key); ok {
+		return current.value, current.exp, true
+	}
+
+	if !m.store(key, entry) {
+		var zero V
+		return zero, time.Time{}, false
+	}
+	return entry.value, entry.exp, false
+}
+
+// Once returns the value associated with the key, if the key does not exist the
+// init function is called and the returned value is set with the specified ttl.
+//
+// The concurrent calls for the same missing key share a single call of init, so
+// init is called at most once per key until the key expires or it's deleted.
+// The calls are not shared with the other methods that set a missing key
+// (e.g. [Map.GetOrLoad]).
+// The init function is called without holding the [Map] lock.
+func (m *Map[K, V]) Once(key K, ttl time.Duration, init func() V) V {
+	if value, ok := m.Get(key); ok {
+		return value
+	}
+
+	value, _ := m.inits.do(key, func() (V, error) {
+		// The key might have been set by a previous call.
+		if value, ok := m.Get(key); ok {
+			return value, nil
+		}
+
+		value := init()
+		m.Set(key, value, ttl)
+		return value, nil
+	})
+
+	return value
+}
+
+// GetOrLoad returns the value associated with the key, if the key does not exist
+// the loader function is called and the returned value is set with the specified ttl.
+//
+// If the loader returns an error, the zero value and the error are returned and
+// the value is not set. If [Config.NegativeTTL] is set, the error is cached for
+// that duration and returned by the subsequent calls without calling the loader,
+// this prevents hammering a failing backend. The cached error is stored as a
+// negative entry that is treated as a missing key by the other methods.
+//
+// If [Config.ServeStaleOnError] is set and the key has expired but it has not been
+// removed yet, its last known value is returned without an error when the loader
+// fails, and the error is not cached.
+//
+// The loader is called without holding the [Map] lock, the loads are serialized per
+// key: the concurrent calls for the same missing key share a single call of the
+// loader and they all receive its results, while the loads of different keys run
+// concurrently, so a slow loader does not block the loads of the other keys.
+// The in-flight loads are tracked per key only while they are running, so the
+// tracking memory is bounded by the number of concurrent loads.
+func (m *Map[K, V]) GetOrLoad(key K, ttl time.Duration, loader func() (V, error)) (V, error) {
+	m.checkTTL(ttl)
+
+	m.mu.RLock()
+	if entry, ok := m.kv.Get(key); ok && !m.expired(entry) {
+		m.markAccess(entry)
+		m.hits.Add(1)
+		value, err := entry.value, entry.err
+		m.mu.RUnlock()
+		return value, err
+	}
+	m.misses.Add(1)
+	m.mu.RUnlock()
+
+	return m.loads.do(key, func() (V, error) {
+		return m.load(key, ttl, loader)
+	})
+}
+
+// load calls the loader function for the key and stores the result, it's called
+// by a single goroutine at a time for a key (See [Map.GetOrLoad]).
+func (m *Map[K, V]) load(key K, ttl time.Duration, loader func() (V, error)) (V, error) {
+	// The expired value served on loader errors.
+	var (
+		stale    V
+		hasStale bool
+	)
+
+	m.mu.RLock()
+	entry, ok := m.kv.Get(key)
+	if ok && !m.expired(entry) {
+		// The key was loaded by a previous call.
+		value, err := entry.value, entry.err
+		m.mu.RUnlock()
+		return value, err
+	} else if ok && m.stale && entry.err == nil {
+		stale, hasStale = entry.value, true
+	}
+	m.mu.RUnlock()
+
+	value, err := loader()
+	if err != nil {
+		if hasStale {
+			return stale, nil
+		}
+
+		var zero V
+
+		if m.negTTL > 0 {
+			entry := m.newRecord(zero, m.negTTL)
+			entry.err = err
+
+			m.mu.Lock()
+			m.store(key, entry)
+			m.mu.Unlock()
+		}
+
+		return zero, err
+	}
+
+	m.Set(key, value, ttl)
+	return value, nil
+}
+
+// GetManyOrLoad returns the live values of the keys, the missing keys are loaded
+// in a single call of the loader function and the returned values are set with
+// the specified ttl.
+//
+// The loader receives the context and the missing keys, the missing keys that
+// are not returned by the loader are not set and they are not included in the
+// returned map.
+//
+// The loads are coordinated with the concurrent calls of this method and of
+// [Map.GetOrLoad], the missing keys that are already being loaded are not passed
+// to the loader, their in-flight loads are waited for instead until the context
+// is done.
+//
+// If the loader returns an error, the loaded keys are not set and the returned
+// map contains the values that were found, along with the error. Unlike
+// [Map.GetOrLoad], the loader errors are never cached.
+func (m *Map[K, V]) GetManyOrLoad(
+	ctx context.Context,
+	keys []K,
+	ttl time.Duration,
+	loader func(ctx context.Context, missing []K) (map[K]V, error),
+) (map[K]V, error) {
+	m.checkTTL(ttl)
+
+	values := make(map[K]V, len(keys))
+
+	var missing []K
+
+	m.mu.RLock()
+	for _, key := range keys {
+		if entry, ok := m.lookup(key); ok {
+			values[key] = entry.value
+		} else {
+			missing = append(missing, key)
+		}
+	}
+	m.mu.RUnlock()
+
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	owned, pending := m.loads.claim(missing)
+
+	err := m.loadMany(ctx, owned, ttl, loader)
+
+	// The loaded keys are returned even if the context is done while waiting.
+	for key, c := range owned {
+		if c.ok && c.err == nil {
+			values[key] = c.value
+		}
+	}
+
+	for key, c := range pending {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return values, errors.Join(err, ctx.Err())
+		}
+
+		switch {
+		case !c.ok:
+			// The key was not loaded.
+		case c.err != nil:
+			err = errors.Join(err, c.err)
+		default:
+			values[key] = c.value
+		}
+	}
+
+	return values, err
+}
+
+// loadMany loads the keys of the calls claimed by [Map.GetManyOrLoad] and sets
+// the results of the calls before completing them.
+func (m *Map[K, V]) loadMany(
+	ctx context.Context,
+	calls map[K]*call[V],
+	ttl time.Duration,
+	loader func(ctx context.Context, missing []K) (map[K]V, error),
+) error {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	defer m.loads.release(calls)
+
+	var missing []K
+
+	// The keys might have been loaded by a previous call.
+	m.mu.RLock()
+	for key, c := range calls {
+		if entry, ok := m.kv.Get(key); ok && m.live(entry) {
+			c.value, c.ok = entry.value, true
+		} else {
+			missing = append(missing, key)
+		}
+	}
+	m.mu.RUnlock()
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	loaded, err := loader(ctx, missing)
+	if err != nil {
+		for _, key := range missing {
+			calls[key].err, calls[key].ok = err, true
+		}
+		return err
+	}
+
+	now := m.time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range missing {
+		value, ok := loaded[key]
+		if !ok {
+			continue
+		}
+
+		m.store(key, &Record[V]{value: value, exp: m.expiration(now, ttl), created: now, modified: now})
+		calls[key].value, calls[key].ok = value, true
+	}
+
+	return nil
+}
+
+// GetOrCompute returns the value associated with the key, if the key does not exist
+// the compute function is called and the returned value is set with the ttl returned
+// by compute.
+//
+// The ttl is decided by compute on each call, there is no default ttl, so a compute
+// function with a default ttl returns it unless it has a more specific ttl for the
+// value (e.g. from an HTTP cache header). A ttl value of 0 makes the key never expire.
+//
+// If compute returns an error, nothing is stored (Unlike [Map.GetOrLoad], errors are
+// never cached) and the zero value and the error are returned.
+//
+// The concurrent calls for the same missing key share a single call of compute, and
+// they all receive its results including the error, the calls are not shared with
+// the other methods that set a missing key (e.g. [Map.GetOrLoad]). The compute
+// function is called without holding the [Map] lock.
+func (m *Map[K, V]) GetOrCompute(key K, compute func() (V, time.Duration, error)) (V, error) {
+	if value, ok := m.Get(key); ok {
+		return value, nil
+	}
+
+	return m.computes.do(key, func() (V, error) {
+		// The key might have been set by a previous call.
+		if value, ok := m.Get(key); ok {
+			return value, nil
+		}
+
+		value, ttl, err := compute()
+		if err != nil {
+			var zero V
+			return zero, err
+		}
+
+		m.Set(key, value, ttl)
+		return value, nil
+	})
+}
+
+// Age returns the time elapsed since the key was created.
+//
+// The creation time is reset when the key is set (e.g. [Map.Set]) and it's preserved
+// when only the value is changed using [Map.Update], so the age of a key is independent
+// from its expiration time.
+//
+// The second bool return value reports whether the key exists in the [Map].
+func (m *Map[K, V]) Age(key K) (time.Duration, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if entry, ok := m.kv.Get(key); ok && m.live(entry) {
+		return m.time.Now().Sub(entry.created), true
+	}
+
+	return 0, false
+}
+
+// GetVersioned returns the value associated with the key and its version.
+//
+// The version of a key changes every time the key is set or its value is updated,
+// it can be passed to [Map.UpdateVersioned] to implement optimistic concurrency
+// for any value type without comparing the values.
+//
+// The third bool return value reports whether the key exists in the [Map].
+func (m *Map[K, V]) GetVersioned(key K) (V, uint64, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if entry, ok := m.lookup(key); ok {
+		return entry.value, entry.version, true
+	}
+
+	var zero V
+	return zero, 0, false
+}
+
+// Inspect returns a view of the key with its value and metadata, all read under
+// a single read lock so they are consistent with each other.
+//
+// Unlike the getter methods, inspecting a key does not count as an access of the
+// key (See [Config.TrackAccess]).
+//
+// The return value ok reports whether the key exists and has not expired.
+func (m *Map[K, V]) Inspect(key K) (view EntryView[K, V], ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.kv.Get(key)
+	if !ok || !m.live(entry) {
+		return view, false
+	}
+
+	view = EntryView[K, V]{
+		Key:        key,
+		Value:      entry.value,
+		Expiration: entry.exp,
+		Version:    entry.version,
+		Created:    entry.created,
+	}
+
+	if m.tracking {
+		view.LastAccess = m.lastAccess(entry)
+	}
+
+	return view, true
+}
+
+// UpdateVersioned changes the value of the key while preserving the expiration
+// time only if the current version of the key matches the expected version.
+//
+// The return value reports whether there was an update (Key exists and the version matches).
+func (m *Map[K, V]) UpdateVersioned(key K, value V, expectedVersion uint64) bool {
+	m.checkValue(value)
+
+	now := m.time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() || m.rejected(value) {
+		return false
+	}
+
+	if entry, ok := m.kv.Get(key); ok && m.live(entry) && entry.version == expectedVersion {
+		entry.value = value
+		entry.modified = now
+		entry.version = m.nextVersion()
+		return true
+	}
+	return false
+}
+
+// Oldest returns the live key-value pair with the earliest creation time.
+//
+// The creation time is the time the key was set (See [Map.Age]), so the order is
+// the insertion order, it's not changed by the reads or by the updates of the value
+// (e.g. [Map.Update]). The order of the keys with the same creation time is unspecified.
+//
+// The read lock is held while scanning all the keys of the [Map], so the cost is O(n).
+//
+// The third bool return value reports whether the [Map] has a live key.
+func (m *Map[K, V]) Oldest() (K, V, bool) {
+	return m.findCreated(func(a, b time.Time) bool { return a.Before(b) })
+}
+
+// Newest returns the live key-value pair with the latest creation time.
+//
+// Similar to [Map.Oldest], the order is the insertion order and the cost is O(n).
+//
+// The third bool return value reports whether the [Map] has a live key.
+func (m *Map[K, V]) Newest() (K, V, bool) {
+	return m.findCreated(func(a, b time.Time) bool { return a.After(b) })
+}
+
+// findCreated returns the live key-value pair whose creation time is preferred over
+// the creation times of all the other keys by the function better.
+func (m *Map[K, V]) findCreated(better func(a, b time.Time) bool) (key K, value V, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var found *Record[V]
+	for k, entry := range m.kv.Range {
+		if m.live(entry) && (found == nil || better(entry.created, found.created)) {
+			key, found = k, entry
+		}
+	}
+
+	if found == nil {
+		return key, value, false
+	}
+	return key, found.value, true
+}
+
+// StaleKeys returns the keys that never expire and that have not been accessed
+// within the olderThan duration, which helps detecting the abandoned keys.
+//
+// A key is accessed when it's set, when its value is updated, or when it's read
+// if [Config.TrackAccess] is enabled. Without access tracking only the writes are
+// taken into account. The last read time is only stored when the tracking is
+// enabled (See [Config.TrackAccess] for its memory cost).
+//
+// The read lock is held while scanning all the keys of the [Map], the cost is O(n).
+func (m *Map[K, V]) StaleKeys(olderThan time.Duration) []K {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.time.Now()
+
+	var keys []K
+	for key, entry := range m.kv.Range {
+		if entry.exp.IsZero() && m.live(entry) && now.Sub(m.lastAccess(entry)) > olderThan {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// ModifiedSince returns the entries whose value was modified after the time t.
+//
+// The modification time of a key is changed when the key is set (e.g. [Map.Set])
+// or when its value is updated (e.g. [Map.Update]), changing only the expiration
+// time of a key (e.g. [Map.GetAndTouchIfBelow]) is not a modification.
+//
+// The read lock is held while scanning all the keys of the [Map].
+func (m *Map[K, V]) ModifiedSince(t time.Time) []Entry[K, V] {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []Entry[K, V]
+	for key, entry := range m.kv.Range {
+		if m.live(entry) && entry.modified.After(t) {
+			entries = append(entries, Entry[K, V]{key, entry.value, entry.exp})
+		}
+	}
+	return entries
+}
+
+// All returns an iterator over key-value pairs from the [Map].
+//
+// Only the entries that have not expired are produced during the iteration.
+//
+// Similar to the map type, the iteration order is not guaranteed.
+func (m *Map[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+
+		for key, entry := range m.kv.Range {
+			if m.live(entry) {
+				if !yield(key, entry.value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// ExpiringEntries returns an iterator over the keys that have an expiration time
+// with their expiration times.
+//
+// The keys that never expire and the expired keys are skipped.
+//
+// Similar to [Map.All], the read lock is held during the iteration.
+func (m *Map[K, V]) ExpiringEntries() iter.Seq2[K, time.Time] {
+	return func(yield func(K, time.Time) bool) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+
+		for key, entry := range m.kv.Range {
+			if !entry.exp.IsZero() && m.live(entry) {
+				if !yield(key, entry.exp) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// ExpiredEntries returns the entries that have expired but have not been removed
+// yet from the [Map], without removing them (See [Map.RemoveExpired]).
+//
+// The returned entries are a point-in-time snapshot of the keys waiting to be
+// removed, the read lock is held while scanning all the keys of the [Map] so the
+// cost is O(n). The cached loader errors are not included.
+func (m *Map[K, V]) ExpiredEntries() []Entry[K, V] {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.time.Now()
+
+	var entries []Entry[K, V]
+	for key, entry := range m.kv.Range {
+		if entry.err == nil && m.expiredAt(entry, now) {
+			entries = append(entries, Entry[K, V]{key, entry.value, entry.exp})
+		}
+	}
+	return entries
+}
+
+// SnapshotIter returns an iterator over a point-in-time snapshot of the key-value pairs
+// in the [Map].
+//
+// The live entries are copied under a brief read lock when the iteration starts,
+// then they are produced from the copy without holding any lock. Unlike [Map.All],
+// it's safe to do slow work or to call the [Map] methods (Including the ones that
+// modify the [Map]) while iterating.
+//
+// Changes made to the [Map] after the snapshot is taken are not observed during the
+// iteration, and the memory required for the snapshot is proportional to the number
+// of entries in the [Map].
+//
+// Similar to the map type, the iteration order is not guaranteed.
+func (m *Map[K, V]) SnapshotIter() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		type pair struct {
+			key   K
+			value V
+		}
+
+		m.mu.RLock()
+		snapshot := make([]pair, 0, m.kv.Len())
+		for key, entry := range m.kv.Range {
+			if m.live(entry) {
+				snapshot = append(snapshot, pair{key, entry.value})
+			}
+		}
+		m.mu.RUnlock()
+
+		for _, p := range snapshot {
+			if !yield(p.key, p.value) {
+				return
+			}
+		}
+	}
+}
+
+// EntriesFiltered returns an iterator over the live entries of the [Map] for which
+// the predicate function pred returns true.
+//
+// The predicate is called for each live entry while holding the read lock when
+// the iteration starts, only the matching entries are copied to a snapshot that
+// is then produced without holding any lock like [Map.SnapshotIter]. So the
+// predicate must not call the [Map] methods, but the loop body can.
+//
+// The iteration stops when the context is done, the context is checked before
+// yielding each entry and before taking the snapshot.
+func (m *Map[K, V]) EntriesFiltered(ctx context.Context, pred func(K, V) bool) iter.Seq[Entry[K, V]] {
+	return func(yield func(Entry[K, V]) bool) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		var snapshot []Entry[K, V]
+
+		m.mu.RLock()
+		for key, entry := range m.kv.Range {
+			if m.live(entry) && pred(key, entry.value) {
+				snapshot = append(snapshot, Entry[K, V]{key, entry.value, entry.exp})
+			}
+		}
+		m.mu.RUnlock()
+
+		for _, e := range snapshot {
+			if ctx.Err() != nil || !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+// Stream returns a channel that receives the live entries of a point-in-time
+// snapshot of the [Map], the channel is closed when all the entries are sent or
+// when the context is done.
+//
+// The live entries are copied under a brief read lock when this method is called,
+// then they are sent by a goroutine without holding any lock like [Map.SnapshotIter].
+// The channel is created with the specified buffer size, the goroutine exits when
+// the context is done even if the entries are not received, so the context must be
+// cancelled when the channel is not drained to avoid leaking the goroutine.
+func (m *Map[K, V]) Stream(ctx context.Context, buffer int) <-chan Entry[K, V] {
+	m.mu.RLock()
+	snapshot := make([]Entry[K, V], 0, m.kv.Len())
+	for key, entry := range m.kv.Range {
+		if m.live(entry) {
+			snapshot = append(snapshot, Entry[K, V]{key, entry.value, entry.exp})
+		}
+	}
+	m.mu.RUnlock()
+
+	ch := make(chan Entry[K, V], max(buffer, 0))
+
+	go func() {
+		defer close(ch)
+
+		for _, e := range snapshot {
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Pages returns an iterator over pages of up to size live entries of the [Map].
+//
+// The keys are collected under a brief read lock when the iteration starts, then
+// each page is read under its own read lock that is released before the page is
+// produced, so the lock is not held during the whole iteration and it's safe to
+// call the [Map] methods while iterating.
+//
+// The pages are not a consistent snapshot of the [Map], the entries are read when
+// their page is built: the keys set after the iteration started are not produced,
+// and the keys that are deleted or expire before their page is built are skipped,
+// so a page might have less than size entries. Only the keys are copied at the start
+// of the iteration, the entries are only materialized one page at a time.
+//
+// A size less than 1 is treated as 1. The pages are never empty.
+func (m *Map[K, V]) Pages(size int) iter.Seq[[]Entry[K, V]] {
+	size = max(size, 1)
+
+	return func(yield func([]Entry[K, V]) bool) {
+		m.mu.RLock()
+		keys := make([]K, 0, m.kv.Len())
+		for key, entry := range m.kv.Range {
+			if m.live(entry) {
+				keys = append(keys, key)
+			}
+		}
+		m.mu.RUnlock()
+
+		for chunk := range slices.Chunk(keys, size) {
+			page := make([]Entry[K, V], 0, len(chunk))
+
+			m.mu.RLock()
+			for _, key := range chunk {
+				if entry, ok := m.kv.Get(key); ok && m.live(entry) {
+					page = append(page, Entry[K, V]{key, entry.value, entry.exp})
+				}
+			}
+			m.mu.RUnlock()
+
+			if len(page) == 0 {
+				continue
+			}
+
+			if !yield(page) {
+				return
+			}
+		}
+	}
+}
+
+// ValuesMatching returns a copy of the values of the keys for which the predicate
+// function pred returns true.
+//
+// The predicate is called while holding the read lock, it must not call the [Map]
+// methods that modify the [Map]. Each matching value is copied into the returned
+// slice, this might be costly for large value types, in which case a pointer
+// value type might be preferred.
+func (m *Map[K, V]) ValuesMatching(pred func(V) bool) []V {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var values []V
+	for _, entry := range m.kv.Range {
+		if m.live(entry) && pred(entry.value) {
+			values = append(values, entry.value)
+		}
+	}
+	return values
+}
+
+// KeysInScoreRange returns the live keys whose score derived from their value by
+// the score function is within the closed interval [min, max].
+//
+// The read lock is held while scanning all the keys of the [Map] and calling score
+// for each live value, so the cost is O(n). It's meant for the occasional queries,
+// the frequent range queries are better served by a secondary index maintained by
+// the caller. The score function must not call the [Map] methods that modify the [Map].
+func (m *Map[K, V]) KeysInScoreRange(score func(V) float64, min, max float64) []K {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []K
+	for key, entry := range m.kv.Range {
+		if !m.live(entry) {
+			continue
+		}
+
+		if s := score(entry.value); s >= min && s <= max {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Count returns the number of keys for which the predicate function pred returns true.
+//
+// The expired keys are not counted, and unlike collecting the matching keys,
+// no memory proportional to the number of keys is allocated.
+// The predicate is called while holding the read lock, it must not call the [Map]
+// methods that modify the [Map].
+func (m *Map[K, V]) Count(pred func(K, V) bool) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var n int
+	for key, entry := range m.kv.Range {
+		if m.live(entry) && pred(key, entry.value) {
+			n++
+		}
+	}
+	return n
+}
+
+// GroupCount returns the number of live keys per bucket label, the label of each
+// key is returned by the function bucket.
+//
+// The bucket function is called while holding the read lock, it must not call
+// the [Map] methods.
+func (m *Map[K, V]) GroupCount(bucket func(K, V) string) map[string]int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for key, entry := range m.kv.Range {
+		if m.live(entry) {
+			counts[bucket(key, entry.value)]++
+		}
+	}
+	return counts
+}
+
+// EqualWithTolerance reports whether the [Map] and the other [Map] have the same
+// live keys with equal values and expiration times.
+//
+// The values are compared using the valEq function, and the expiration times are
+// considered equal if they differ by at most tol. A key that never expires only
+// matches a key that never expires in the other [Map].
+//
+// The live entries of the [Map] are copied under its read lock, then they are
+// compared while holding the read lock of the other [Map], so the two locks are
+// never held at the same time.
+func (m *Map[K, V]) EqualWithTolerance(other *Map[K, V], valEq func(a, b V) bool, tol time.Duration) bool {
+	if m == other {
+		return true
+	}
+
+	m.mu.RLock()
+	entries := make(map[K]Entry[K, V], m.kv.Len())
+	for key, entry := range m.kv.Range {
+		if m.live(entry) {
+			entries[key] = Entry[K, V]{key, entry.value, entry.exp}
+		}
+	}
+	m.mu.RUnlock()
+
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+
+	var n int
+	for key, entry := range other.kv.Range {
+		if !other.live(entry) {
+			continue
+		}
+
+		current, ok := entries[key]
+		if !ok || current.Expiration.IsZero() != entry.exp.IsZero() {
+			return false
+		}
+
+		if diff := current.Expiration.Sub(entry.exp).Abs(); diff > tol {
+			return false
+		}
+
+		if !valEq(current.Value, entry.value) {
+			return false
+		}
+
+		n++
+	}
+
+	return n == len(entries)
+}
+
+// IntersectKeys returns the keys that are live in both the [Map] and the other [Map].
+//
+// The live keys of the other [Map] are copied under its read lock, then the
+// [Map] is scanned under its own read lock, the two locks are never held at the
+// same time so the calls on two maps in opposite directions cannot deadlock.
+// The result is not an atomic snapshot of both maps, the keys changed between
+// the two scans might be reported based on either state.
+func (m *Map[K, V]) IntersectKeys(other *Map[K, V]) []K {
+	return m.compareKeys(other, true)
+}
+
+// DifferenceKeys returns the keys that are live in the [Map] but not in the other [Map].
+//
+// The maps are scanned one after the other like [Map.IntersectKeys].
+func (m *Map[K, V]) DifferenceKeys(other *Map[K, V]) []K {
+	return m.compareKeys(other, false)
+}
+
+// compareKeys returns the live keys of the [Map] that are live in the other [Map]
+// if shared is true, or that are not live in the other [Map] if shared is false.
+func (m *Map[K, V]) compareKeys(other *Map[K, V], shared bool) []K {
+	var others map[K]struct{}
+	if other != m {
+		other.mu.RLock()
+		others = make(map[K]struct{}, other.kv.Len())
+		for key, entry := range other.kv.Range {
+			if other.live(entry) {
+				others[key] = struct{}{}
+			}
+		}
+		other.mu.RUnlock()
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []K
+	for key, entry := range m.kv.Range {
+		if !m.live(entry) {
+			continue
+		}
+
+		_, ok := others[key]
+		if other == m {
+			ok = true
+		}
+
+		if ok == shared {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// RangeDelete calls the function f for each live key-value pair of the [Map] and
+// removes the keys for which f returns true for delete, the iteration stops when
+// f returns true for stop.
+//
+// The function f is called on a snapshot of the live entries without holding any
+// lock, so it's safe to call the [Map] methods from f. The deletions are applied
+// afterwards in a single write locked pass. A key that was replaced or changed
+// (e.g. [Map.Update]) between the snapshot and the deletion is not deleted, since
+// f decided on a previous value.
+//
+// It returns the number of keys that were removed.
+func (m *Map[K, V]) RangeDelete(f func(K, V) (delete bool, stop bool)) int {
+	type snapshot struct {
+		key     K
+		value   V
+		version uint64
+	}
+
+	m.mu.RLock()
+	entries := make([]snapshot, 0, m.kv.Len())
+	for key, entry := range m.kv.Range {
+		if m.live(entry) {
+			entries = append(entries, snapshot{key, entry.value, entry.version})
+		}
+	}
+	m.mu.RUnlock()
+
+	var deletions []snapshot
+	for _, e := range entries {
+		del, stop := f(e.key, e.value)
+		if del {
+			deletions = append(deletions, e)
+		}
+		if stop {
+			break
+		}
+	}
+
+	if len(deletions) == 0 {
+		return 0
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return 0
+	}
+
+	var removed int
+	for _, e := range deletions {
+		if entry, ok := m.kv.Get(e.key); ok && entry.version == e.version {
+			m.remove(e.key)
+			removed++
+		}
+	}
+	m.autoCompact()
+
+	return removed
+}
+
+// ExpireNow expires the specified keys at the current time without removing them.
+//
+// Unlike deleting the keys, the expired keys go through the normal expiration:
+// they are treated as missing by the reads, the keys set with a grace period
+// (See [Map.SetWithGrace]) are still served by [Map.GetStale] during their grace
+// period, and the expiration callbacks are called when the keys are removed by
+// the cleanup. This is useful to invalidate the keys while keeping their stale
+// values available until they are refreshed.
+//
+// It returns the number of live keys that were expired.
+func (m *Map[K, V]) ExpireNow(keys []K) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return 0
+	}
+
+	// A key is expired when the current time is after its expiration time.
+	exp := m.time.Now().Add(-time.Nanosecond)
+
+	var n int
+	for _, key := range keys {
+		if entry, ok := m.kv.Get(key); ok && m.live(entry) {
+			entry.exp = exp
+			m.trackTouch(entry)
+			n++
+		}
+	}
+	return n
+}
+
+// Delete removes a key from the [Map].
+func (m *Map[K, V]) Delete(key K) {
+	m.mu.Lock()
+	if m.writable() {
+		m.remove(key)
+		m.autoCompact()
+	}
+	m.mu.Unlock()
+}
+
+// Clear removes all the entries from the [Map].
+func (m *Map[K, V]) Clear() {
+	m.mu.Lock()
+	if m.writable() {
+		m.clear()
+	}
+	m.mu.Unlock()
+}
+
+// clear removes all the entries and the groups, and notifies the watchers.
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) clear() {
+	m.kv.Clear()
+	m.count.Store(0)
+	clear(m.groups)
+	if m.diffLog > 0 {
+		m.trackClear()
+	}
+	m.notifyAll()
+}
+
+// Run runs the cleanup loop in the calling goroutine until the context is cancelled
+// or the [Map] is stopped, then it stops the [Map] (See [Map.Stop]).
+//
+// It's designed to be launched by a supervisor (e.g. errgroup.Group) that owns the
+// goroutine, it should be used with [Config.DisableAutoCleanup] so the [Map] does
+// not start its own cleanup goroutine, otherwise both loops remove the expired keys.
+//
+// The returned error is always nil, the cancellation of the context is the normal
+// way to stop the loop.
+func (m *Map[K, V]) Run(ctx context.Context) error {
+	if m.strict {
+		if m.auto {
+			panic("xmap: Run called on a map with the cleanup goroutine enabled")
+		}
+		if m.Stopped() {
+			panic("xmap: Run called on a stopped map")
+		}
+	}
+
+	m.loop(ctx.Done())
+	m.Stop()
+	return nil
+}
+
+// cleanup removes expired keys from the [Map] in an interval.
+//
+// The cleanup is stopped by calling [Map.Stop].
+func (m *Map[K, V]) cleanup() {
+	m.loop(nil)
+}
+
+// loop removes expired keys from the [Map] in an interval until the [Map]
+// is stopped or the done channel is closed.
+func (m *Map[K, V]) loop(done <-chan struct{}) {
+	ticker := m.time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	// Set as active.
+	m.active.Store(1)
+	defer m.active.Store(0)
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-done:
+			return
+		case <-ticker.C():
+			if m.paused.Load() {
+				continue
+			}
+			start := m.time.Now()
+			end := m.sweep()
+			if m.overrun != nil {
+				if elapsed := end.Sub(start); elapsed > m.interval {
+					m.overrun(elapsed - m.interval)
+				}
+			}
+			if m.window > 0 {
+				m.refreshExpiring(m.time.Now())
+			}
+		}
+	}
+}
+
+// sweep runs a cleanup pass of the cleanup loop, it removes the expired keys and
+// rebuilds the underlying map if needed (See [Config.ShrinkOnCleanup]), then it
+// records the time of the pass (See [Map.LastCleanup]) and returns it.
+func (m *Map[K, V]) sweep() time.Time {
+	if m.RemoveExpired() > 0 && m.shrinkCl {
+		m.shrinkIfNeeded()
+	}
+	end := m.time.Now()
+	m.lastCleanup.Store(&end)
+	return end
+}
+
+// CleanupActive reports whether the cleanup goroutine is active.
+func (m *Map[K, V]) CleanupActive() bool {
+	return m.active.Load() == 1
+}
+
+// RemoveExpired checks the [Map] keys and removes the expired ones.
+//
+// It returns the number of keys that were removed.
+func (m *Map[K, V]) RemoveExpired() int {
+	return m.removeExpired(m.time.Now(), nil)
+}
+
+// RemoveExpiredWithCallback removes the expired keys like [Map.RemoveExpired] and
+// calls the function fn once with all the removed entries.
+//
+// The function fn is called without holding the lock and only if at least one key
+// was removed, it can be used to archive the expired entries in batches.
+// The cached loader errors are removed but they are not passed to fn.
+//
+// It returns the number of keys that were removed.
+func (m *Map[K, V]) RemoveExpiredWithCallback(fn func([]Entry[K, V])) int {
+	return m.removeExpired(m.time.Now(), fn)
+}
+
+// Tick removes the keys that have expired at the time now.
+//
+// It's meant to be called periodically by the caller's own scheduler when the
+// background cleanup goroutine is disabled using [Config.DisableAutoCleanup],
+// making the [Map] fully deterministic and goroutine-free.
+//
+// It returns the number of keys that were removed.
+func (m *Map[K, V]) Tick(now time.Time) int {
+	return m.removeExpired(now, nil)
+}
+
+// removeExpired removes the keys that have expired at the time now, the function
+// batch is called with the removed entries if it's not nil.
+func (m *Map[K, V]) removeExpired(now time.Time, batch func([]Entry[K, V])) int {
+	if m.frzClean && m.Frozen() {
+		return 0
+	}
+
+	// Expired keys.
+	var expired []K
+
+	// Find the expired keys.
+	m.mu.RLock()
+	for key, entry := range m.kv.Range {
+		if m.removableAt(entry, now) {
+			expired = append(expired, key)
+		}
+	}
+	m.mu.RUnlock()
+
+	// Number of removed keys.
+	var removed int
+	// Expiration callbacks of the removed keys.
+	var callbacks []func()
+	// The removed entries.
+	var entries []Entry[K, V]
+
+	// Remove the expired keys.
+	m.mu.Lock()
+	locked := m.lockTime()
+	for _, key := range expired {
+		// The key might have been replaced after it was found.
+		if entry, ok := m.kv.Get(key); ok && m.removableAt(entry, now) {
+			m.remove(key)
+			removed++
+
+			if batch != nil && entry.err == nil {
+				entries = append(entries, Entry[K, V]{key, entry.value, entry.exp})
+			}
+
+			if onExpire := entry.onExpire(); onExpire != nil {
+				callbacks = append(callbacks, func() { onExpire(entry.value) })
+			}
+		}
+	}
+	m.removeExpiredGroups(now)
+	if removed > 0 {
+		m.autoCompact()
+	}
+	m.trackCleanupLock(locked)
+	m.mu.Unlock()
+
+	// Run the callbacks without holding the lock.
+	for _, callback := range callbacks {
+		callback()
+	}
+
+	if len(entries) > 0 {
+		batch(entries)
+	}
+
+	return removed
+}
+
+// Trim removes the keys with the soonest expiration times until at most n keys
+// are left in the [Map].
+//
+// The expired keys and the cached loader errors are removed first, then the keys
+// are removed in order of their expiration times, the keys that never expire are
+// removed last. The keys with the same expiration time are removed in the order
+// in which they were last written (The least recently written first), so the
+// removed keys are deterministic for a sequence of writes.
+//
+// The keys are sorted while holding the write lock, so the cost is O(n log n).
+// The expiration callbacks of the removed keys (See [Map.SetWithCallback]) are
+// called after releasing the lock.
+//
+// If a spill map is set (See [Map.SetSpillTo]), the removed live keys are moved
+// to the spill map with their expiration times after releasing the lock.
+//
+// It returns the number of keys that were removed.
+func (m *Map[K, V]) Trim(n int) int {
+	removed, spill, spilled, callbacks := m.trim(n)
+	if len(spilled) > 0 {
+		spill.SetEntries(spilled)
+	}
+
+	// Run the callbacks without holding the lock.
+	for _, callback := range callbacks {
+		callback()
+	}
+
+	return removed
+}
+
+// trim removes the keys like [Map.Trim] and returns the number of removed keys,
+// along with the spill map, the removed live entries to move to the spill map and
+// the expiration callbacks of the removed keys.
+func (m *Map[K, V]) trim(n int) (removed int, spill *Map[K, V], spilled []Entry[K, V], callbacks []func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return 0, nil, nil, nil
+	}
+
+	excess := m.kv.Len() - max(n, 0)
+	if excess <= 0 {
+		return 0, nil, nil, nil
+	}
+
+	type candidate struct {
+		key     K
+		exp     time.Time
+		version uint64
+		expired bool
+	}
+
+	now := m.time.Now()
+
+	candidates := make([]candidate, 0, m.kv.Len())
+	for key, entry := range m.kv.Range {
+		expired := entry.err != nil || m.expiredAt(entry, now)
+		candidates = append(candidates, candidate{key, entry.exp, entry.version, expired})
+	}
+
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		switch {
+		case a.expired != b.expired:
+			if a.expired {
+				return -1
+			}
+			return 1
+		case a.exp.IsZero() != b.exp.IsZero():
+			// Never expiring keys last.
+			if a.exp.IsZero() {
+				return 1
+			}
+			return -1
+		}
+		if c := a.exp.Compare(b.exp); c != 0 {
+			return c
+		}
+		// Least recently written first.
+		return cmp.Compare(a.version, b.version)
+	})
+
+	for _, c := range candidates[:excess] {
+		entry, _ := m.kv.Get(c.key)
+		if m.spill != nil && !c.expired {
+			spilled = append(spilled, Entry[K, V]{c.key, entry.value, entry.exp})
+		}
+		if onExpire := entry.onExpire(); onExpire != nil {
+			callbacks = append(callbacks, func() { onExpire(entry.value) })
+		}
+		m.remove(c.key)
+	}
+
+	return excess, m.spill, spilled, callbacks
+}
+
+// Compact removes the expired keys and rebuilds the underlying map to reclaim memory
+// if the fraction of the keys removed since the peak size of the map reaches
+// [Config.CompactThreshold], whether they expired or they were deleted.
+//
+// Go maps do not shrink after deleting keys, a rebuild allocates a new map sized
+// for the remaining keys, it's only supported by the default in-memory [Backend].
+// The expired keys are removed like [Map.RemoveExpired] and the write lock is only
+// held for the rebuild if it's needed.
+//
+// It returns the number of keys that were removed.
+func (m *Map[K, V]) Compact() int {
+	removed := m.RemoveExpired()
+	m.shrinkIfNeeded()
+	return removed
+}
+
+// shrinkIfNeeded rebuilds the underlying map if the fraction of the keys removed
+// since its peak size reaches [Config.CompactThreshold].
+func (m *Map[K, V]) shrinkIfNeeded() {
+	s, ok := m.kv.(shrinker)
+	if !ok {
+		return
+	}
+
+	m.mu.RLock()
+	peak, length := s.peak(), m.kv.Len()
+	m.mu.RUnlock()
+
+	if peak == 0 || float64(peak-length)/float64(peak) < m.compact {
+		return
+	}
+
+	m.mu.Lock()
+	m.shrink(0)
+	m.mu.Unlock()
+}
+
+// shrink releases the unused memory of the [Backend] if it's supported, keeping
+// room for at least capacity records.
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) shrink(capacity int) {
+	if s, ok := m.kv.(shrinker); ok {
+		s.shrink(capacity)
+	}
+}
+
+// autoCompact rebuilds the underlying map if the number of keys dropped below the
+// [Config.AutoCompactThreshold] fraction of its peak size.
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) autoCompact() {
+	if m.autoCmp <= 0 {
+		return
+	}
+
+	if s, ok := m.kv.(shrinker); ok && float64(m.kv.Len()) < m.autoCmp*float64(s.peak()) {
+		s.shrink(0)
+	}
+}
+
+// store creates or replaces the record of the key with a new version, it's a no-op
+// if the [Map] is frozen (See [Map.writable]).
+//
+// The return value reports whether the record was stored.
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) store(key K, entry *Record[V]) bool {
+	if !m.writable() || (entry.err == nil && m.rejected(entry.value)) {
+		return false
+	}
+
+	entry.version = m.nextVersion()
+	if m.diffLog > 0 {
+		m.trackStore(key, entry)
+	}
+	if m.tracking {
+		// The read time is set while holding the read lock (See [Map.markAccess]).
+		entry.metadata()
+	}
+	m.kv.Set(key, entry)
+	m.count.Store(int64(m.kv.Len()))
+	return true
+}
+
+// remove removes the key and notifies its watchers (See [Map.OnKeyExpire]).
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) remove(key K) {
+	n := m.kv.Len()
+	m.kv.Delete(key)
+	// Only the keys that were held are recorded as removed.
+	if m.diffLog > 0 && m.kv.Len() < n {
+		m.trackRemove(key)
+	}
+	m.count.Store(int64(m.kv.Len()))
+	m.notify(key)
+}
+
+// nextVersion returns the next version number of the [Map].
+//
+// The version numbers are assigned from a single counter of the [Map], so a version
+// is never reused for the same key even if the key is deleted and set again.
+// The counter wraps around to 0 after reaching the maximum uint64 value, which is
+// not reachable in practice.
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) nextVersion() uint64 {
+	m.version++
+	return m.version
+}
+
+// lookup returns the live record of the key, the read is recorded as an access of
+// the record (See [Config.TrackAccess]) and it's counted as a hit or a miss in the
+// [Stats].
+//
+// The read lock must be held when calling this method.
+func (m *Map[K, V]) lookup(key K) (*Record[V], bool) {
+	if entry, ok := m.kv.Get(key); ok && m.live(entry) {
+		m.markAccess(entry)
+		m.hits.Add(1)
+		return entry, true
+	}
+
+	m.misses.Add(1)
+	return nil, false
+}
+
+// markAccess records the current time as the last read time of the record
+// if the access tracking is enabled.
+//
+// At least the read lock must be held when calling this method.
+func (m *Map[K, V]) markAccess(entry *Record[V]) {
+	if m.tracking && entry.meta != nil {
+		entry.meta.accessed.Store(int64(m.time.Now().Sub(m.epoch)))
+	}
+}
+
+// lastAccess returns the last access time of the record, which is the latest of
+// the last modification time and the last read time.
+func (m *Map[K, V]) lastAccess(entry *Record[V]) time.Time {
+	last := entry.modified
+	if entry.meta == nil {
+		return last
+	}
+	if read := m.epoch.Add(time.Duration(entry.meta.accessed.Load())); read.After(last) {
+		last = read
+	}
+	return last
+}
+
+// checkWrite panics in strict mode if the [Map] is stopped or the ttl is negative.
+//
+// It must be called before acquiring the lock.
+func (m *Map[K, V]) checkWrite(ttl time.Duration) {
+	if m.strict && m.Stopped() {
+		panic("xmap: key set on a stopped map")
+	}
+	m.checkTTL(ttl)
+}
+
+// rejected reports whether the value is a nil value rejected by [Config.RejectNil].
+func (m *Map[K, V]) rejected(value V) bool {
+	return m.rejNil && isNil(value)
+}
+
+// checkValue panics in strict mode if the value is rejected by [Config.RejectNil].
+//
+// It must be called without holding the lock.
+func (m *Map[K, V]) checkValue(value V) {
+	if m.strict && m.rejected(value) {
+		panic("xmap: nil value rejected")
+	}
+}
+
+// isNil reports whether the value is nil or holds a nil pointer, map, slice,
+// function, channel or interface.
+func isNil(value any) bool {
+	if value == nil {
+		return true
+	}
+
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface, reflect.UnsafePointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+// checkTTL panics in strict mode if the ttl is negative.
+func (m *Map[K, V]) checkTTL(ttl time.Duration) {
+	if m.strict && ttl < 0 {
+		panic("xmap: negative ttl " + ttl.String())
+	}
+}
+
+// newRecord creates a new [Record] created at the current time that expires
+// after the specified ttl, a ttl value of 0 means that it never expires.
+func (m *Map[K, V]) newRecord(value V, ttl time.Duration) *Record[V] {
+	m.checkWrite(ttl)
+	m.checkValue(value)
+
+	now := m.time.Now()
+	entry := &Record[V]{value: value, created: now, modified: now}
+
+	entry.exp = m.expiration(now, ttl)
+
+	return entry
+}
+
+// expiration returns the expiration time of a key set at the time now with the ttl,
+// or a zero time value if the ttl is not positive (Never expires).
+//
+// The ttl is jittered if [Config.TTLJitter] is set.
+func (m *Map[K, V]) expiration(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+
+	if m.jitter > 0 {
+		offset := time.Duration(m.randN(2*int64(m.jitter)+1)) - m.jitter
+		ttl = max(ttl+offset, 1)
+	}
+
+	return now.Add(ttl)
+}
+
+// randN returns a random number in the half-open interval [0,n).
+func (m *Map[K, V]) randN(n int64) int64 {
+	if m.rng == nil {
+		return rand.Int64N(n)
+	}
+
+	return m.rng.Int64N(n)
+}
+
+// live reports whether a [Record] has not expired and is not a negative record.
+func (m *Map[K, V]) live(entry *Record[V]) bool {
+	return entry.err == nil && !m.expired(entry)
+}
+
+// expired reports whether a [Record] has expired.
+func (m *Map[K, V]) expired(entry *Record[V]) bool {
+	return m.expiredAt(entry, m.time.Now())
+}
+
+// expiredAt reports whether a [Record] has expired at the time now.
+//
+// A record older than the max age is considered as expired.
+func (m *Map[K, V]) expiredAt(entry *Record[V], now time.Time) bool {
+	if m.pauseExp && m.paused.Load() {
+		return false
+	}
+	if m.maxAge > 0 && now.Sub(entry.created) > m.maxAge {
+		return true
+	}
+	return !entry.exp.IsZero() && now.After(entry.exp)
 }
 
-// expired reports whether an [entry] has expired.
-func (m *Map[K, V]) expired(entry *entry[V]) bool {
-	return !entry.exp.IsZero() && m.time.Now().After(entry.exp)
+// removableAt reports whether a [Record] has expired at the time now and its
+// grace period has elapsed (See [Map.SetWithGrace]).
+func (m *Map[K, V]) removableAt(entry *Record[V], now time.Time) bool {
+	return m.expiredAt(entry, now.Add(-entry.grace()))
 }
@@ -1,8 +1,16 @@
 package xmap_test
 
 import (
+	"context"
+	"errors"
 	"maps"
+	"math/rand/v2"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -778,3 +786,2853 @@ func TestMapAllConcurrentIterations(t *testing.T) {
 		}
 	}
 }
+
+func TestMapEntriesFiltered(t *testing.T) {
+	t.Parallel()
+
+	testTime := newMockTime(time.Now())
+
+	m := xmap.NewWithConfig[int, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	for i := range 10 {
+		m.Set(i, i*10, 0)
+	}
+	m.Set(20, 200, time.Second) // Expired.
+	testTime.Advance(time.Minute)
+
+	even := func(key, _ int) bool { return key%2 == 0 }
+
+	got := make(map[int]int)
+	for e := range m.EntriesFiltered(context.Background(), even) {
+		got[e.Key] = e.Value
+		m.Delete(e.Key) // No lock held while iterating.
+	}
+
+	if want := map[int]int{0: 0, 2: 20, 4: 40, 6: 60, 8: 80}; !maps.Equal(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var n int
+	for range m.EntriesFiltered(ctx, func(int, int) bool { return true }) {
+		n++
+		cancel()
+	}
+
+	if n != 1 {
+		t.Errorf("want iteration to stop after cancellation, got %d entries", n)
+	}
+}
+
+func TestMapStream(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[int, int]()
+	defer m.Stop()
+
+	want := make(map[int]int)
+	for i := range 10 {
+		m.Set(i, i*10, 0)
+		want[i] = i * 10
+	}
+
+	got := make(map[int]int)
+	for e := range m.Stream(context.Background(), 2) {
+		got[e.Key] = e.Value
+		m.Delete(e.Key) // No lock held while consuming.
+	}
+
+	if !maps.Equal(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	m.Set(1, 1, 0)
+	m.Set(2, 2, 0)
+
+	// The producer exits when cancelled before the entries are received.
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := m.Stream(ctx, 0)
+	<-ch
+	cancel()
+
+	for range ch {
+		// Drained until closed, at most one more entry is received.
+	}
+}
+
+func TestMapSnapshotIterator(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, time.Minute)
+	m.Set("b", 2, time.Hour)
+	m.Set("c", 3, 0) // Never expires.
+
+	// Advance the time to make "a" expire.
+	testTime.Advance(2 * time.Minute)
+
+	want := map[string]int{"b": 2, "c": 3}
+	got := make(map[string]int)
+
+	for k, v := range m.SnapshotIter() {
+		got[k] = v
+		// Modifying the map while iterating must not deadlock.
+		m.Delete(k)
+		m.Set("new", 100, 0)
+	}
+
+	if !maps.Equal(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	// The expired key "a" and the key created during the iteration should be left.
+	if m.Len() != 2 {
+		t.Errorf("want map length %d, got %d", 2, m.Len())
+	}
+}
+
+func TestMapName(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		Name: "sessions",
+	})
+	defer m.Stop()
+
+	if got := m.Name(); got != "sessions" {
+		t.Errorf("want map name %q, got %q", "sessions", got)
+	}
+}
+
+func TestMapGetOrLoad(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, int]()
+	defer m.Stop()
+
+	calls := 0
+	loader := func() (int, error) {
+		calls++
+		return 10, nil
+	}
+
+	for range 2 {
+		value, err := m.GetOrLoad("a", time.Hour, loader)
+		if err != nil {
+			t.Fatalf("unexpected loader error: %v", err)
+		}
+
+		if value != 10 {
+			t.Errorf("want value %d, got %d", 10, value)
+		}
+	}
+
+	// The loaded value is cached.
+	if calls != 1 {
+		t.Errorf("want loader calls %d, got %d", 1, calls)
+	}
+
+	if value, ok := m.Get("a"); !ok || value != 10 {
+		t.Errorf("want value %d for key %q, got %d", 10, "a", value)
+	}
+}
+
+func TestMapGetOrLoadNegativeCaching(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:  testTime,
+		NegativeTTL: time.Second,
+	})
+	defer m.Stop()
+
+	wantErr := errors.New("backend down")
+
+	calls := 0
+	loader := func() (int, error) {
+		calls++
+		return 0, wantErr
+	}
+
+	for range 2 {
+		if _, err := m.GetOrLoad("a", time.Hour, loader); !errors.Is(err, wantErr) {
+			t.Errorf("want error %v, got %v", wantErr, err)
+		}
+	}
+
+	// The error is cached.
+	if calls != 1 {
+		t.Errorf("want loader calls %d, got %d", 1, calls)
+	}
+
+	// The negative entry must not be returned as a value.
+	if _, ok := m.Get("a"); ok {
+		t.Errorf("key %q with a cached error must not exist", "a")
+	}
+
+	// Advance the time to make the negative entry expire.
+	testTime.Advance(time.Second + time.Nanosecond)
+
+	if _, err := m.GetOrLoad("a", time.Hour, loader); !errors.Is(err, wantErr) {
+		t.Errorf("want error %v, got %v", wantErr, err)
+	}
+
+	if calls != 2 {
+		t.Errorf("want loader calls %d, got %d", 2, calls)
+	}
+}
+
+func TestMapSetWithCallback(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	expired := make(map[string]int)
+	onExpire := func(key string, value int) {
+		expired[key] = value
+	}
+
+	m.SetWithCallback("a", 1, time.Minute, onExpire)
+	m.SetWithCallback("b", 2, time.Minute, onExpire)
+	m.SetWithCallback("c", 3, time.Minute, onExpire)
+
+	m.Update("a", 10) // The callback receives the updated value.
+	m.Set("b", 20, 0) // Replacing the key cancels the callback.
+	m.Delete("c")     // Deleting the key cancels the callback.
+
+	testTime.Advance(2 * time.Minute)
+
+	if removed := m.RemoveExpired(); removed != 1 {
+		t.Fatalf("want %d key removals, got %d", 1, removed)
+	}
+
+	want := map[string]int{"a": 10}
+	if !maps.Equal(want, expired) {
+		t.Errorf("want expired keys %v, got %v", want, expired)
+	}
+}
+
+func TestMapReplaceAndGet(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	if old, ok := m.ReplaceAndGet("a", 1, time.Hour); ok {
+		t.Fatalf("want false replacing a non existing key, got true with value %d", old)
+	}
+
+	if _, ok := m.Get("a"); ok {
+		t.Fatalf("key %q must not be created", "a")
+	}
+
+	m.Set("a", 1, time.Minute)
+
+	old, ok := m.ReplaceAndGet("a", 2, time.Hour)
+	if !ok {
+		t.Fatalf("key %q was not replaced", "a")
+	}
+
+	if old != 1 {
+		t.Errorf("want previous value %d, got %d", 1, old)
+	}
+
+	wantExpiration := now.Add(time.Hour)
+	if value, exp, _ := m.GetWithExpiration("a"); value != 2 || !exp.Equal(wantExpiration) {
+		t.Errorf("want value %d with expiration %v, got %d with expiration %v", 2, wantExpiration, value, exp)
+	}
+
+	// Expired keys are not replaced.
+	testTime.Advance(2 * time.Hour)
+
+	if _, ok := m.ReplaceAndGet("a", 3, time.Hour); ok {
+		t.Error("want false replacing an expired key, got true")
+	}
+}
+
+func TestMapDisableAutoCleanupWithTick(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, time.Minute)
+	m.Set("b", 2, time.Hour)
+
+	// Make sure no cleanup goroutine was started.
+	if retryUntil(20*time.Millisecond, m.CleanupActive) {
+		t.Fatal("cleanup goroutine must not be started")
+	}
+
+	if removed := m.Tick(now.Add(time.Minute)); removed != 0 {
+		t.Errorf("want %d key removals at the exact expiration time, got %d", 0, removed)
+	}
+
+	if removed := m.Tick(now.Add(2 * time.Minute)); removed != 1 {
+		t.Errorf("want %d key removals, got %d", 1, removed)
+	}
+
+	if m.Len() != 1 {
+		t.Errorf("want map length %d, got %d", 1, m.Len())
+	}
+}
+
+func TestMapValuesMatching(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	m.Set("b", 2, 0)
+	m.Set("c", 3, 0)
+	m.Set("d", 4, time.Minute)
+
+	// Advance the time to make "d" expire.
+	testTime.Advance(2 * time.Minute)
+
+	got := m.ValuesMatching(func(v int) bool {
+		return v%2 == 0
+	})
+	want := []int{2}
+
+	if !slices.Equal(want, got) {
+		t.Errorf("want values %v, got %v", want, got)
+	}
+}
+
+func TestMapExpirationUsesMonotonicClock(t *testing.T) {
+	t.Parallel()
+
+	// The time returned by time.Now carries a monotonic clock reading
+	// that is preserved by the mock time source when advancing the time.
+	now := time.Now()
+	if now == now.Round(0) {
+		t.Skip("the system clock has no monotonic clock reading")
+	}
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, time.Minute)
+
+	// The expiration time must keep the monotonic clock reading, the comparisons
+	// of 2 times having a monotonic clock reading ignore the wall clock readings,
+	// so the wall clock changes do not affect the expiration of the keys.
+	_, exp, ok := m.GetWithExpiration("a")
+	if !ok {
+		t.Fatalf("key %q does not exist in the map", "a")
+	}
+	if exp == exp.Round(0) {
+		t.Errorf("want an expiration time with a monotonic clock reading, got %v", exp)
+	}
+	if want := now.Add(time.Minute); !exp.Equal(want) || exp.Sub(now) != time.Minute {
+		t.Errorf("want expiration time %v, got %v", want, exp)
+	}
+
+	testTime.Set(now.Add(time.Minute))
+	if _, ok := m.Get("a"); !ok {
+		t.Errorf("key %q must not expire at the exact expiration time", "a")
+	}
+
+	testTime.Set(now.Add(time.Minute + time.Nanosecond))
+	if _, ok := m.Get("a"); ok {
+		t.Errorf("key %q must expire after the expiration time", "a")
+	}
+
+	if removed := m.RemoveExpired(); removed != 1 {
+		t.Errorf("want %d removed keys, got %d", 1, removed)
+	}
+}
+
+func TestMapCompact(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[int, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	for i := range 100 {
+		ttl := time.Minute
+		if i%4 == 0 {
+			ttl = 0 // Never expires.
+		}
+		m.Set(i, i, ttl)
+	}
+
+	if removed := m.Compact(); removed != 0 {
+		t.Errorf("want %d key removals, got %d", 0, removed)
+	}
+
+	// Advance the time to make 75% of the keys expire.
+	testTime.Advance(2 * time.Minute)
+
+	if removed := m.Compact(); removed != 75 {
+		t.Errorf("want %d key removals, got %d", 75, removed)
+	}
+
+	if m.Len() != 25 {
+		t.Fatalf("want map length %d, got %d", 25, m.Len())
+	}
+
+	for i := 0; i < 100; i += 4 {
+		if v, ok := m.Get(i); !ok || v != i {
+			t.Errorf("want value %d for key %d after compaction, got %d", i, i, v)
+		}
+	}
+}
+
+func TestMapAge(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	if _, ok := m.Age("a"); ok {
+		t.Fatal("want false getting the age of a non existing key, got true")
+	}
+
+	m.Set("a", 1, time.Hour)
+	testTime.Advance(time.Minute)
+
+	// Update preserves the creation time.
+	m.Update("a", 2)
+	testTime.Advance(time.Minute)
+
+	if age, ok := m.Age("a"); !ok || age != 2*time.Minute {
+		t.Errorf("want key %q age %v, got %v", "a", 2*time.Minute, age)
+	}
+
+	// Set resets the creation time.
+	m.Set("a", 3, time.Hour)
+	testTime.Advance(time.Second)
+
+	if age, ok := m.Age("a"); !ok || age != time.Second {
+		t.Errorf("want key %q age %v, got %v", "a", time.Second, age)
+	}
+}
+
+// checkZeroValueAfterExpiration verifies that the zero value of V is returned
+// for an expired key and after deleting the key.
+func checkZeroValueAfterExpiration[V any](t *testing.T, value, updated V) {
+	t.Helper()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, V](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	isZero := func(v V) bool {
+		return reflect.ValueOf(&v).Elem().IsZero()
+	}
+
+	m.Set("a", value, time.Minute)
+	m.Set("b", value, 0)
+
+	if got, ok := m.Get("a"); !ok || isZero(got) {
+		t.Fatalf("want non zero value for key %q, got %v", "a", got)
+	}
+
+	testTime.Advance(2 * time.Minute)
+
+	if got, ok := m.Get("a"); ok || !isZero(got) {
+		t.Errorf("want zero value for expired key %q, got %v", "a", got)
+	}
+
+	if got, _, ok := m.GetWithExpiration("a"); ok || !isZero(got) {
+		t.Errorf("want zero value with expiration for expired key %q, got %v", "a", got)
+	}
+
+	if ok := m.Update("a", updated); ok {
+		t.Errorf("key %q should not be updated on expiration", "a")
+	}
+
+	if got, ok := m.Get("a"); ok || !isZero(got) {
+		t.Errorf("want zero value for expired key %q after update, got %v", "a", got)
+	}
+
+	m.Delete("b")
+
+	if got, ok := m.Get("b"); ok || !isZero(got) {
+		t.Errorf("want zero value for deleted key %q, got %v", "b", got)
+	}
+}
+
+func TestMapZeroValueAfterExpiration(t *testing.T) {
+	t.Parallel()
+
+	type record struct {
+		name string
+		tags []string
+	}
+
+	n := 10
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{"struct", func(t *testing.T) {
+			checkZeroValueAfterExpiration(t, record{"a", []string{"x"}}, record{"b", nil})
+		}},
+		{"pointer", func(t *testing.T) {
+			checkZeroValueAfterExpiration(t, &n, &n)
+		}},
+		{"slice", func(t *testing.T) {
+			checkZeroValueAfterExpiration(t, []int{1, 2, 3}, []int{4})
+		}},
+		{"map", func(t *testing.T) {
+			checkZeroValueAfterExpiration(t, map[string]int{"a": 1}, map[string]int{"b": 2})
+		}},
+		{"interface", func(t *testing.T) {
+			checkZeroValueAfterExpiration[any](t, "value", 100)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tt.run(t)
+		})
+	}
+}
+
+func TestMapMaxAge(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+		MaxAge:     time.Hour,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0) // Never expires.
+	m.Set("b", 2, 2*time.Hour)
+
+	// Keep the value updated.
+	testTime.Advance(30 * time.Minute)
+	m.Update("a", 10)
+
+	testTime.Advance(30 * time.Minute)
+
+	if _, ok := m.Get("a"); !ok {
+		t.Errorf("key %q must not expire at the exact max age", "a")
+	}
+
+	testTime.Advance(time.Nanosecond)
+
+	for _, key := range []string{"a", "b"} {
+		if _, ok := m.Get(key); ok {
+			t.Errorf("key %q older than the max age did not expire", key)
+		}
+	}
+
+	if removed := m.RemoveExpired(); removed != 2 {
+		t.Errorf("want %d key removals, got %d", 2, removed)
+	}
+}
+
+func TestMapLenExcludesExpired(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		excludeExpired bool
+		wantLen        int
+	}{
+		{"counts expired", false, 3},
+		{"excludes expired", true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			now := time.Now()
+			testTime := newMockTime(now)
+
+			m := xmap.NewWithConfig[string, int](xmap.Config{
+				TimeSource:         testTime,
+				LenExcludesExpired: tt.excludeExpired,
+			})
+			defer m.Stop()
+
+			m.Set("a", 1, time.Minute)
+			m.Set("b", 2, time.Minute)
+			m.Set("c", 3, 0)
+
+			if m.Len() != 3 {
+				t.Fatalf("want map length %d, got %d", 3, m.Len())
+			}
+
+			testTime.Advance(2 * time.Minute)
+
+			if m.Len() != tt.wantLen {
+				t.Errorf("want map length %d, got %d", tt.wantLen, m.Len())
+			}
+		})
+	}
+}
+
+func TestMapExpiringEntries(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, time.Second)
+	m.Set("b", 2, time.Minute)
+	m.Set("c", 3, time.Hour)
+	m.Set("d", 4, 0) // Never expires.
+
+	// Advance the time to make "a" expire.
+	testTime.Advance(2 * time.Second)
+
+	want := map[string]time.Time{
+		"b": now.Add(time.Minute),
+		"c": now.Add(time.Hour),
+	}
+	got := maps.Collect(m.ExpiringEntries())
+
+	if !maps.EqualFunc(want, got, time.Time.Equal) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestMapGetOrSetWithExpiration(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	value, exp, existed := m.GetOrSetWithExpiration("a", 1, time.Minute)
+	if existed || value != 1 || !exp.Equal(now.Add(time.Minute)) {
+		t.Fatalf("want new key with value %d and expiration %v, got %d, %v (existed %t)",
+			1, now.Add(time.Minute), value, exp, existed)
+	}
+
+	value, exp, existed = m.GetOrSetWithExpiration("a", 2, time.Hour)
+	if !existed || value != 1 || !exp.Equal(now.Add(time.Minute)) {
+		t.Errorf("want existing key with value %d and expiration %v, got %d, %v (existed %t)",
+			1, now.Add(time.Minute), value, exp, existed)
+	}
+
+	if value, existed := m.GetOrSet("b", 3, 0); existed || value != 3 {
+		t.Errorf("want new key with value %d, got %d (existed %t)", 3, value, existed)
+	}
+
+	// Expired keys are replaced.
+	testTime.Advance(2 * time.Minute)
+
+	if value, existed := m.GetOrSet("a", 4, 0); existed || value != 4 {
+		t.Errorf("want new key with value %d, got %d (existed %t)", 4, value, existed)
+	}
+}
+
+func TestMapSetManyFunc(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.SetManyFunc(map[string]int{"a": 1, "b": 2, "c": 0}, func(_ string, v int) time.Duration {
+		return time.Duration(v) * time.Minute
+	})
+
+	want := map[string]time.Time{
+		"a": now.Add(time.Minute),
+		"b": now.Add(2 * time.Minute),
+		"c": {}, // Never expires.
+	}
+
+	for key, wantExp := range want {
+		if _, exp, ok := m.GetWithExpiration(key); !ok || !exp.Equal(wantExp) {
+			t.Errorf("want key %q expiration %v, got %v", key, wantExp, exp)
+		}
+	}
+}
+
+func TestMapTrim(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, time.Second) // Expired.
+	m.Set("b", 2, 0)           // Never expires.
+	m.Set("c", 3, time.Hour)
+	m.Set("d", 4, time.Minute)
+	m.Set("e", 5, 2*time.Hour)
+
+	testTime.Advance(2 * time.Second)
+
+	if removed := m.Trim(10); removed != 0 {
+		t.Errorf("want %d key removals, got %d", 0, removed)
+	}
+
+	if removed := m.Trim(3); removed != 2 {
+		t.Errorf("want %d key removals, got %d", 2, removed)
+	}
+
+	got := maps.Collect(m.All())
+	want := map[string]int{"b": 2, "c": 3, "e": 5}
+
+	if !maps.Equal(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	// The keys that never expire are removed last.
+	if removed := m.Trim(1); removed != 2 {
+		t.Errorf("want %d key removals, got %d", 2, removed)
+	}
+
+	if _, ok := m.Get("b"); !ok {
+		t.Errorf("key %q that never expires must be removed last", "b")
+	}
+}
+
+func TestMapTrimTiebreaker(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: newMockTime(time.Now()),
+	})
+	defer m.Stop()
+
+	// Same expiration times.
+	for _, key := range []string{"c", "a", "d", "b"} {
+		m.Set(key, 1, time.Minute)
+	}
+	m.Update("c", 2) // Last written.
+
+	for _, want := range []string{"a", "d", "b"} {
+		m.Trim(m.Len() - 1)
+
+		if _, ok := m.Get(want); ok {
+			t.Fatalf("want least recently written key %q to be removed", want)
+		}
+	}
+
+	if value, ok := m.Get("c"); !ok || value != 2 {
+		t.Errorf("want key %q to be removed last, got value %d (exists %t)", "c", value, ok)
+	}
+}
+
+func TestMapTrimCallbacks(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: newMockTime(time.Now()),
+	})
+	defer m.Stop()
+
+	evicted := make(map[string]int)
+	onExpire := func(key string, value int) {
+		// The callbacks run without holding the lock.
+		m.Set("callback", 0, 0)
+		evicted[key] = value
+	}
+
+	m.SetWithCallback("a", 1, time.Second, onExpire)
+	m.SetWithCallback("b", 2, time.Minute, onExpire)
+	m.SetWithCallback("c", 3, time.Hour, onExpire)
+
+	if removed := m.Trim(1); removed != 2 {
+		t.Errorf("want %d key removals, got %d", 2, removed)
+	}
+
+	if want := map[string]int{"a": 1, "b": 2}; !maps.Equal(want, evicted) {
+		t.Errorf("want evicted keys %v, got %v", want, evicted)
+	}
+}
+
+func TestMapGetAndTouchIfBelow(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	if _, ok := m.GetAndTouchIfBelow("a", time.Minute, time.Hour); ok {
+		t.Fatal("want false getting a non existing key, got true")
+	}
+
+	m.Set("a", 1, 10*time.Minute)
+
+	// The remaining TTL is above the threshold.
+	if value, ok := m.GetAndTouchIfBelow("a", time.Minute, time.Hour); !ok || value != 1 {
+		t.Fatalf("want value %d, got %d", 1, value)
+	}
+
+	if _, exp, _ := m.GetWithExpiration("a"); !exp.Equal(now.Add(10 * time.Minute)) {
+		t.Errorf("want expiration %v, got %v", now.Add(10*time.Minute), exp)
+	}
+
+	// The remaining TTL is below the threshold.
+	testTime.Advance(9*time.Minute + time.Second)
+
+	if value, ok := m.GetAndTouchIfBelow("a", time.Minute, time.Hour); !ok || value != 1 {
+		t.Fatalf("want value %d, got %d", 1, value)
+	}
+
+	wantExp := testTime.Now().Add(time.Hour)
+	if _, exp, _ := m.GetWithExpiration("a"); !exp.Equal(wantExp) {
+		t.Errorf("want expiration %v, got %v", wantExp, exp)
+	}
+}
+
+func TestMapRun(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+
+	// Wait until the cleanup loop is active.
+	if isActive := retryUntil(time.Second, m.CleanupActive); !isActive {
+		t.Fatal("cleanup loop did not start in time")
+	}
+
+	m.Set("a", 1, time.Minute)
+	testTime.Advance(2 * time.Minute)
+	testTime.Tick()
+
+	if removed := retryUntil(time.Second, func() bool {
+		return m.Len() == 0
+	}); !removed {
+		t.Errorf("want map length %d, got %d", 0, m.Len())
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for Run to return")
+	}
+
+	if !m.Stopped() {
+		t.Error("map was not stopped after Run returned")
+	}
+}
+
+func TestMapModifiedSince(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	m.Set("b", 2, time.Hour)
+	m.Set("c", 3, time.Hour)
+
+	since := testTime.Now()
+	testTime.Advance(time.Second)
+
+	m.Update("a", 10)
+	m.Set("d", 4, 0)
+	m.GetAndTouchIfBelow("b", 2*time.Hour, 2*time.Hour) // Not a modification.
+
+	got := m.ModifiedSince(since)
+	slices.SortFunc(got, func(a, b xmap.Entry[string, int]) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	want := []xmap.Entry[string, int]{
+		{Key: "a", Value: 10},
+		{Key: "d", Value: 4},
+	}
+
+	if !slices.Equal(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestMapDrainAndStop(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+
+	m.Set("a", 1, time.Second) // Expired.
+	m.Set("b", 2, time.Hour)
+	m.Set("c", 3, 0)
+
+	testTime.Advance(2 * time.Second)
+
+	got := make(map[string]int)
+	m.DrainAndStop(func(k string, v int) {
+		if _, ok := got[k]; ok {
+			t.Errorf("key %q was delivered more than once", k)
+		}
+		got[k] = v
+	})
+
+	want := map[string]int{"b": 2, "c": 3}
+	if !maps.Equal(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	if !m.Stopped() {
+		t.Error("map was not stopped")
+	}
+
+	if m.Len() != 0 {
+		t.Errorf("want map length %d, got %d", 0, m.Len())
+	}
+
+	// A stopped map is not drained again.
+	m.DrainAndStop(func(k string, _ int) {
+		t.Errorf("key %q was delivered after the map was stopped", k)
+	})
+}
+
+func TestMapVersionedUpdate(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, []int]()
+	defer m.Stop()
+
+	if _, _, ok := m.GetVersioned("a"); ok {
+		t.Fatal("want false getting a non existing key, got true")
+	}
+
+	m.Set("a", []int{1}, 0)
+
+	value, version, ok := m.GetVersioned("a")
+	if !ok {
+		t.Fatalf("key %q does not exist in the map", "a")
+	}
+
+	if ok := m.UpdateVersioned("a", append(value, 2), version); !ok {
+		t.Fatal("key was not updated with the current version")
+	}
+
+	// The version was changed by the update.
+	if ok := m.UpdateVersioned("a", []int{3}, version); ok {
+		t.Error("key was updated with a stale version")
+	}
+
+	got, newVersion, _ := m.GetVersioned("a")
+	if !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("want value %v, got %v", []int{1, 2}, got)
+	}
+
+	if newVersion <= version {
+		t.Errorf("want version greater than %d, got %d", version, newVersion)
+	}
+
+	// Setting the key again after deleting it never reuses a version.
+	m.Delete("a")
+	m.Set("a", nil, 0)
+
+	if _, v, _ := m.GetVersioned("a"); v <= newVersion {
+		t.Errorf("want version greater than %d, got %d", newVersion, v)
+	}
+}
+
+func TestMapSetEntries(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	entries := []xmap.Entry[string, int]{
+		{Key: "a", Value: 1, Expiration: now.Add(time.Minute)},
+		{Key: "b", Value: 2}, // Never expires.
+		{Key: "c", Value: 3, Expiration: now.Add(-time.Minute)},
+	}
+
+	m.SetEntries(entries)
+
+	if m.Len() != 2 {
+		t.Fatalf("want map length %d, got %d", 2, m.Len())
+	}
+
+	for _, e := range entries[:2] {
+		value, exp, ok := m.GetWithExpiration(e.Key)
+		if !ok || value != e.Value || !exp.Equal(e.Expiration) {
+			t.Errorf("want key %q value %d with expiration %v, got %d with expiration %v",
+				e.Key, e.Value, e.Expiration, value, exp)
+		}
+	}
+}
+
+func TestMapSetReturning(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	if _, _, existed := m.SetReturning("a", 1, time.Minute); existed {
+		t.Error("want missing key a")
+	}
+
+	value, exp, existed := m.SetReturning("a", 2, time.Hour)
+	if !existed || value != 1 || !exp.Equal(now.Add(time.Minute)) {
+		t.Errorf("want previous value %d with expiration %v, got %d with %v (existed %t)",
+			1, now.Add(time.Minute), value, exp, existed)
+	}
+
+	if value, exp, _ := m.GetWithExpiration("a"); value != 2 || !exp.Equal(now.Add(time.Hour)) {
+		t.Errorf("want value %d with expiration %v, got %d with %v", 2, now.Add(time.Hour), value, exp)
+	}
+
+	m.Set("b", 1, time.Second)
+	testTime.Advance(time.Minute)
+
+	if _, _, existed := m.SetReturning("b", 2, 0); existed {
+		t.Error("want expired key b to be reported as missing")
+	}
+}
+
+func TestMapSetManyR(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	m.Set("b", 2, time.Second)
+	testTime.Advance(time.Minute)
+
+	if replaced := m.SetR("a", 10, 0); !replaced {
+		t.Error("want live key a to be replaced")
+	}
+	if replaced := m.SetR("z", 26, 0); replaced {
+		t.Error("want missing key z to be inserted")
+	}
+
+	entries := []xmap.Entry[string, int]{
+		{Key: "a", Value: 100},                                   // Replaced.
+		{Key: "b", Value: 200},                                   // Expired, inserted.
+		{Key: "c", Value: 300, Expiration: now.Add(time.Hour)},   // Inserted.
+		{Key: "d", Value: 400, Expiration: now.Add(time.Second)}, // Skipped.
+	}
+
+	inserted, replaced := m.SetManyR(entries)
+	if inserted != 2 || replaced != 1 {
+		t.Errorf("want %d inserted and %d replaced keys, got %d and %d", 2, 1, inserted, replaced)
+	}
+
+	if value, ok := m.Get("b"); !ok || value != 200 {
+		t.Errorf("want key b value %d, got %d (exists %t)", 200, value, ok)
+	}
+}
+
+func TestMapIsExpired(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	if expired, exists := m.IsExpired("a"); expired || exists {
+		t.Errorf("want non existing key, got expired %t and exists %t", expired, exists)
+	}
+
+	m.Set("a", 1, time.Minute)
+
+	if expired, exists := m.IsExpired("a"); expired || !exists {
+		t.Errorf("want existing live key, got expired %t and exists %t", expired, exists)
+	}
+
+	testTime.Advance(2 * time.Minute)
+
+	if expired, exists := m.IsExpired("a"); !expired || !exists {
+		t.Errorf("want existing expired key, got expired %t and exists %t", expired, exists)
+	}
+
+	m.RemoveExpired()
+
+	if expired, exists := m.IsExpired("a"); expired || exists {
+		t.Errorf("want removed key, got expired %t and exists %t", expired, exists)
+	}
+}
+
+func TestMapMonotonicClock(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		MonotonicClock: true,
+	})
+	defer m.Stop()
+
+	before := time.Now()
+	m.Set("a", 1, time.Hour)
+	after := time.Now()
+
+	_, exp, ok := m.GetWithExpiration("a")
+	if !ok {
+		t.Fatalf("key %q does not exist in the map", "a")
+	}
+
+	// The wall clock expiration time is reconstructed from the monotonic base.
+	// Allow some tolerance for the base reading.
+	lower, upper := before.Add(time.Hour).Add(-time.Second), after.Add(time.Hour).Add(time.Second)
+	if exp.Before(lower) || exp.After(upper) {
+		t.Errorf("want expiration between %v and %v, got %v", lower, upper, exp)
+	}
+
+	m.Set("b", 2, time.Millisecond)
+
+	if expired := retryUntil(time.Second, func() bool {
+		_, ok := m.Get("b")
+		return !ok
+	}); !expired {
+		t.Errorf("key %q did not expire", "b")
+	}
+}
+
+func TestMapStrictMode(t *testing.T) {
+	t.Parallel()
+
+	// checkPanics reports whether the function f panics.
+	checkPanics := func(f func()) (panicked bool) {
+		defer func() {
+			panicked = recover() != nil
+		}()
+		f()
+		return false
+	}
+
+	tests := []struct {
+		name string
+		f    func(m *xmap.Map[string, int])
+	}{
+		{"negative ttl", func(m *xmap.Map[string, int]) {
+			m.Set("a", 1, -time.Second)
+		}},
+		{"set after stop", func(m *xmap.Map[string, int]) {
+			m.Stop()
+			m.Set("a", 1, time.Second)
+		}},
+		{"run with cleanup goroutine", func(m *xmap.Map[string, int]) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			m.Run(ctx)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			strict := xmap.NewWithConfig[string, int](xmap.Config{Strict: true})
+			defer strict.Stop()
+
+			if !checkPanics(func() { tt.f(strict) }) {
+				t.Error("want panic in strict mode")
+			}
+
+			lenient := xmap.New[string, int]()
+			defer lenient.Stop()
+
+			if checkPanics(func() { tt.f(lenient) }) {
+				t.Error("unexpected panic in normal mode")
+			}
+		})
+	}
+}
+
+func TestMapRejectNil(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[string, error](xmap.Config{RejectNil: true})
+	defer m.Stop()
+
+	var typedNil *os.PathError
+
+	m.Set("nil", nil, 0)
+	m.Set("typed", typedNil, 0)
+	m.Set("ok", errors.New("value"), 0)
+
+	if m.Len() != 1 {
+		t.Errorf("want map length %d, got %d", 1, m.Len())
+	}
+
+	if m.Update("ok", nil) || m.Update("ok", typedNil) {
+		t.Error("want nil updates to be rejected")
+	}
+
+	if value, _ := m.Get("ok"); value == nil {
+		t.Error("want non nil value")
+	}
+
+	strict := xmap.NewWithConfig[string, any](xmap.Config{RejectNil: true, Strict: true})
+	defer strict.Stop()
+
+	strict.SetGroup("group", time.Hour)
+
+	cases := map[string]func(){
+		"Set":        func() { strict.Set("nil", nil, 0) },
+		"SetUntil":   func() { strict.SetUntil("nil", nil, time.Time{}, 0) },
+		"SetAtUnix":  func() { strict.SetAtUnix("nil", nil, 0) },
+		"SetInGroup": func() { strict.SetInGroup("group", "nil", nil, 0) },
+	}
+
+	for name, set := range cases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: want panic in strict mode", name)
+				}
+			}()
+			set()
+		}()
+	}
+
+	if strict.Len() != 0 {
+		t.Errorf("want map length %d, got %d", 0, strict.Len())
+	}
+}
+
+func TestMapStaleKeys(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:  testTime,
+		TrackAccess: true,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	m.Set("b", 2, 0)
+	m.Set("c", 3, 0)
+	m.Set("d", 4, 2*time.Hour) // Expiring keys are never stale.
+
+	testTime.Advance(30 * time.Minute)
+	m.Get("a")       // Read.
+	m.Update("b", 3) // Write.
+
+	testTime.Advance(45 * time.Minute)
+
+	want := []string{"c"}
+	got := m.StaleKeys(time.Hour)
+
+	if !slices.Equal(want, got) {
+		t.Errorf("want stale keys %v, got %v", want, got)
+	}
+}
+
+func TestMapDeleteOlderThan(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		opts []xmap.AgeOption
+		want []string // Remaining keys.
+	}{
+		"creation time": {
+			want: []string{"c", "d"},
+		},
+		"last access time": {
+			opts: []xmap.AgeOption{xmap.ByLastAccess()},
+			want: []string{"a", "c", "d"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			testTime := newMockTime(time.Now())
+
+			m := xmap.NewWithConfig[string, int](xmap.Config{
+				TimeSource:  testTime,
+				TrackAccess: true,
+			})
+			defer m.Stop()
+
+			m.Set("a", 1, 0)
+			m.Set("b", 2, 0)
+			m.Set("e", 5, 10*time.Minute) // Expired keys are not counted.
+
+			testTime.Advance(30 * time.Minute)
+			m.Get("a")
+			m.Set("c", 3, 0)
+			m.Set("d", 4, 2*time.Hour)
+
+			testTime.Advance(45 * time.Minute)
+
+			wantRemoved := 4 - len(tc.want)
+			if removed := m.DeleteOlderThan(time.Hour, tc.opts...); removed != wantRemoved {
+				t.Errorf("want %d removed keys, got %d", wantRemoved, removed)
+			}
+
+			m.RemoveExpired()
+
+			got := slices.Sorted(maps.Keys(maps.Collect(m.All())))
+			if !slices.Equal(tc.want, got) {
+				t.Errorf("want keys %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestMapGetWithFreshness(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		FreshnessThreshold: time.Minute,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 10*time.Minute)
+	m.Set("b", 2, 0) // Never expires.
+
+	if value, fresh, ok := m.GetWithFreshness("a"); !ok || !fresh || value != 1 {
+		t.Errorf("want fresh value %d, got %d (fresh %t, ok %t)", 1, value, fresh, ok)
+	}
+
+	testTime.Advance(9 * time.Minute)
+
+	if value, fresh, ok := m.GetWithFreshness("a"); !ok || fresh || value != 1 {
+		t.Errorf("want stale value %d, got %d (fresh %t, ok %t)", 1, value, fresh, ok)
+	}
+
+	if _, fresh, ok := m.GetWithFreshness("b"); !ok || !fresh {
+		t.Errorf("want fresh key that never expires, got fresh %t, ok %t", fresh, ok)
+	}
+
+	testTime.Advance(2 * time.Minute)
+
+	if _, _, ok := m.GetWithFreshness("a"); ok {
+		t.Errorf("key %q did not expire", "a")
+	}
+}
+
+func TestMapGetEntries(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, time.Hour)
+	m.Set("b", 2, 0)
+	m.Set("c", 3, time.Second)
+
+	testTime.Advance(2 * time.Second)
+
+	got := m.GetEntries([]string{"b", "missing", "c", "a"})
+	want := []xmap.Entry[string, int]{
+		{Key: "b", Value: 2},
+		{Key: "a", Value: 1, Expiration: now.Add(time.Hour)},
+	}
+
+	if !slices.EqualFunc(want, got, func(a, b xmap.Entry[string, int]) bool {
+		return a.Key == b.Key && a.Value == b.Value && a.Expiration.Equal(b.Expiration)
+	}) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestMapGroupCount(t *testing.T) {
+	t.Parallel()
+
+	testTime := newMockTime(time.Now())
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.Set("acme:1", 1, 0)
+	m.Set("acme:2", 2, 0)
+	m.Set("globex:1", 3, 0)
+	m.Set("globex:2", 4, time.Minute) // Expired.
+
+	testTime.Advance(2 * time.Minute)
+
+	got := m.GroupCount(func(key string, _ int) string {
+		tenant, _, _ := strings.Cut(key, ":")
+		return tenant
+	})
+
+	if want := map[string]int{"acme": 2, "globex": 1}; !maps.Equal(want, got) {
+		t.Errorf("want counts %v, got %v", want, got)
+	}
+}
+
+func TestMapCount(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	m.Set("b", 2, 0)
+	m.Set("c", 3, 0)
+	m.Set("d", 4, time.Second)
+
+	testTime.Advance(2 * time.Second)
+
+	even := func(_ string, v int) bool {
+		return v%2 == 0
+	}
+
+	if got := m.Count(even); got != 1 {
+		t.Errorf("want count %d, got %d", 1, got)
+	}
+}
+
+func TestMapOnce(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, int]()
+	defer m.Stop()
+
+	var calls atomic.Int32
+	init := func() int {
+		calls.Add(1)
+		time.Sleep(10 * time.Millisecond) // Give time for the other goroutines.
+		return 42
+	}
+
+	const goroutines = 10
+
+	var wg sync.WaitGroup
+	results := make([]int, goroutines)
+
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = m.Once("a", time.Hour, init)
+		}()
+	}
+
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("want init calls %d, got %d", 1, got)
+	}
+
+	for _, got := range results {
+		if got != 42 {
+			t.Errorf("want value %d, got %d", 42, got)
+		}
+	}
+
+	// The stored value is returned without calling init.
+	if got := m.Once("a", time.Hour, init); got != 42 || calls.Load() != 1 {
+		t.Errorf("want stored value %d without calling init, got %d", 42, got)
+	}
+}
+
+func TestMapIntersectAndDifferenceKeys(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	a := xmap.NewWithConfig[string, int](xmap.Config{TimeSource: testTime})
+	defer a.Stop()
+	b := xmap.NewWithConfig[string, int](xmap.Config{TimeSource: testTime})
+	defer b.Stop()
+
+	a.Set("x", 1, 0)
+	a.Set("y", 2, 0)
+	a.Set("z", 3, time.Minute) // Expired.
+	b.Set("y", 20, 0)
+	b.Set("x", 10, time.Minute) // Expired.
+	b.Set("w", 40, 0)
+
+	testTime.Advance(2 * time.Minute)
+
+	if got, want := slices.Sorted(slices.Values(a.IntersectKeys(b))), []string{"y"}; !slices.Equal(want, got) {
+		t.Errorf("want intersection %v, got %v", want, got)
+	}
+
+	if got, want := slices.Sorted(slices.Values(a.DifferenceKeys(b))), []string{"x"}; !slices.Equal(want, got) {
+		t.Errorf("want difference %v, got %v", want, got)
+	}
+
+	if got := a.DifferenceKeys(a); len(got) != 0 {
+		t.Errorf("want empty difference with itself, got %v", got)
+	}
+}
+
+func TestMapEqualWithTolerance(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	timeA := newMockTime(now)
+	timeB := newMockTime(now.Add(time.Millisecond))
+
+	a := xmap.NewWithConfig[string, int](xmap.Config{TimeSource: timeA})
+	defer a.Stop()
+	b := xmap.NewWithConfig[string, int](xmap.Config{TimeSource: timeB})
+	defer b.Stop()
+
+	eq := func(x, y int) bool { return x == y }
+
+	a.Set("a", 1, time.Hour)
+	a.Set("b", 2, 0)
+	b.Set("a", 1, time.Hour)
+	b.Set("b", 2, 0)
+
+	if !a.EqualWithTolerance(b, eq, time.Millisecond) {
+		t.Error("want maps equal within tolerance")
+	}
+
+	if a.EqualWithTolerance(b, eq, time.Microsecond) {
+		t.Error("want maps not equal outside tolerance")
+	}
+
+	// A key that never expires only matches a key that never expires.
+	b.Set("b", 2, time.Hour)
+	if a.EqualWithTolerance(b, eq, time.Hour) {
+		t.Error("want never expiring key not equal to an expiring key")
+	}
+
+	b.Set("b", 2, 0)
+	b.Set("c", 3, 0)
+	if a.EqualWithTolerance(b, eq, time.Millisecond) || b.EqualWithTolerance(a, eq, time.Millisecond) {
+		t.Error("want maps with different keys not equal")
+	}
+}
+
+func TestMapOnCleanupOverrun(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+	interval := time.Minute
+	lags := make(chan time.Duration, 1)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		CleanupInterval:  interval,
+		OnCleanupOverrun: func(lag time.Duration) { lags <- lag },
+		TimeSource:       testTime,
+	})
+	defer m.Stop()
+
+	if isActive := retryUntil(time.Second, m.CleanupActive); !isActive {
+		t.Fatal("cleanup loop did not start in time")
+	}
+
+	// Simulate a slow sweep by advancing the time from the expiration callback.
+	m.SetWithCallback("a", 1, time.Second, func(string, int) {
+		testTime.Advance(interval + 5*time.Second)
+	})
+
+	testTime.Advance(2 * time.Second)
+	testTime.Tick()
+
+	select {
+	case lag := <-lags:
+		if want := 5 * time.Second; lag != want {
+			t.Errorf("want lag %v, got %v", want, lag)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("overrun hook not called")
+	}
+}
+
+func TestMapSetFromMap(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.Set("a", 0, 0)
+	m.SetFromMap(map[string]int{"a": 1, "b": 2}, time.Minute)
+
+	want := map[string]int{"a": 1, "b": 2}
+	if got := maps.Collect(m.All()); !maps.Equal(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	for key := range want {
+		if _, exp, _ := m.GetWithExpiration(key); !exp.Equal(now.Add(time.Minute)) {
+			t.Errorf("want key %q expiration %v, got %v", key, now.Add(time.Minute), exp)
+		}
+	}
+}
+
+func TestMapInspect(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:  testTime,
+		TrackAccess: true,
+	})
+	defer m.Stop()
+
+	if _, ok := m.Inspect("a"); ok {
+		t.Error("want missing key not found")
+	}
+
+	m.Set("a", 1, time.Hour)
+	_, version, _ := m.GetVersioned("a")
+
+	testTime.Advance(time.Minute)
+	m.Get("a")
+	testTime.Advance(time.Minute)
+
+	want := xmap.EntryView[string, int]{
+		Key:        "a",
+		Value:      1,
+		Expiration: now.Add(time.Hour),
+		Version:    version,
+		Created:    now,
+		LastAccess: now.Add(time.Minute),
+	}
+
+	got, ok := m.Inspect("a")
+	if !ok {
+		t.Fatal("want key found")
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestMapOnKeyExpire(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+	})
+	defer m.Stop()
+
+	closed := func(ch <-chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	if !closed(m.OnKeyExpire("missing")) {
+		t.Error("want closed channel for a missing key")
+	}
+
+	m.Set("a", 1, time.Minute)
+	m.Set("b", 2, 0)
+
+	a1, a2 := m.OnKeyExpire("a"), m.OnKeyExpire("a")
+	b := m.OnKeyExpire("b")
+
+	// Replacing the key extends the watch to the new value.
+	m.Set("a", 1, time.Hour)
+	testTime.Advance(2 * time.Minute)
+	m.RemoveExpired()
+
+	if closed(a1) || closed(a2) {
+		t.Error("want channels open after replacing the key")
+	}
+
+	testTime.Advance(time.Hour)
+	m.RemoveExpired()
+
+	if !closed(a1) || !closed(a2) {
+		t.Error("want channels closed after the key expired")
+	}
+
+	if closed(b) {
+		t.Error("want channel open for a live key")
+	}
+
+	m.Delete("b")
+	if !closed(b) {
+		t.Error("want channel closed after deleting the key")
+	}
+
+	m.Set("c", 3, 0)
+	c := m.OnKeyExpire("c")
+	m.Stop()
+
+	if !closed(c) {
+		t.Error("want channel closed after stopping the map")
+	}
+}
+
+func TestMapExpiredEntries(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, time.Minute)
+	m.Set("b", 2, time.Hour)
+	m.Set("c", 3, 0)
+
+	testTime.Advance(2 * time.Minute)
+
+	want := []xmap.Entry[string, int]{{Key: "a", Value: 1, Expiration: now.Add(time.Minute)}}
+	if got := m.ExpiredEntries(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want expired entries %v, got %v", want, got)
+	}
+
+	// The expired entries are not removed.
+	if got := m.Len(); got != 3 {
+		t.Errorf("want length %d, got %d", 3, got)
+	}
+}
+
+func TestMapMustGetAndGetPtr(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, *int]()
+	defer m.Stop()
+
+	m.Set("nil", nil, 0)
+
+	if got := m.MustGet("nil"); got != nil {
+		t.Errorf("want stored nil value, got %v", got)
+	}
+
+	if got := m.GetPtr("nil"); got == nil || *got != nil {
+		t.Errorf("want pointer to the stored nil value, got %v", got)
+	}
+
+	if got := m.GetPtr("missing"); got != nil {
+		t.Errorf("want nil pointer for a missing key, got %v", got)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("want MustGet to panic for a missing key")
+		}
+	}()
+
+	m.MustGet("missing")
+}
+
+func TestMapRemoveExpiredWithCallback(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, time.Minute)
+	m.Set("b", 2, time.Minute)
+	m.Set("c", 3, time.Hour)
+
+	testTime.Advance(2 * time.Minute)
+
+	var calls int
+	var got []xmap.Entry[string, int]
+
+	removed := m.RemoveExpiredWithCallback(func(entries []xmap.Entry[string, int]) {
+		calls++
+		got = entries
+	})
+
+	if removed != 2 {
+		t.Errorf("want %d removed keys, got %d", 2, removed)
+	}
+
+	if calls != 1 {
+		t.Fatalf("want callback called %d time, got %d", 1, calls)
+	}
+
+	slices.SortFunc(got, func(a, b xmap.Entry[string, int]) int { return strings.Compare(a.Key, b.Key) })
+
+	want := []xmap.Entry[string, int]{
+		{Key: "a", Value: 1, Expiration: now.Add(time.Minute)},
+		{Key: "b", Value: 2, Expiration: now.Add(time.Minute)},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want removed entries %v, got %v", want, got)
+	}
+
+	// The callback is not called if no keys are removed.
+	m.RemoveExpiredWithCallback(func([]xmap.Entry[string, int]) { calls++ })
+	if calls != 1 {
+		t.Errorf("want callback not called, got %d calls", calls)
+	}
+}
+
+func TestMapLockModeReadPreferring(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[int, int](xmap.Config{
+		LockMode: xmap.LockReadPreferring,
+	})
+	defer m.Stop()
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			m.Set(i, i, 0)
+		}()
+		go func() {
+			defer wg.Done()
+			m.Get(i)
+		}()
+	}
+	wg.Wait()
+
+	if got := m.Len(); got != 10 {
+		t.Errorf("want length %d, got %d", 10, got)
+	}
+}
+
+func TestMapPages(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[int, int]()
+	defer m.Stop()
+
+	for i := range 10 {
+		m.Set(i, i, 0)
+	}
+
+	var sizes []int
+	got := make(map[int]int)
+
+	for page := range m.Pages(4) {
+		sizes = append(sizes, len(page))
+		for _, e := range page {
+			got[e.Key] = e.Value
+		}
+		// The lock is not held between the pages.
+		m.Set(100, 100, 0)
+	}
+
+	if want := []int{4, 4, 2}; !slices.Equal(want, sizes) {
+		t.Errorf("want page sizes %v, got %v", want, sizes)
+	}
+
+	if len(got) != 10 {
+		t.Errorf("want %d entries, got %d", 10, len(got))
+	}
+
+	// Stop the iteration early.
+	var pages int
+	for range m.Pages(1) {
+		pages++
+		break
+	}
+
+	if pages != 1 {
+		t.Errorf("want %d page, got %d", 1, pages)
+	}
+}
+
+func TestMapAutoCompact(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[int, int](xmap.Config{
+		TimeSource:           testTime,
+		DisableAutoCleanup:   true,
+		AutoCompactThreshold: 0.5,
+	})
+	defer m.Stop()
+
+	for i := range 100 {
+		ttl := time.Minute
+		if i%10 == 0 {
+			ttl = 0 // Never expires.
+		}
+		m.Set(i, i, ttl)
+	}
+
+	testTime.Advance(2 * time.Minute)
+
+	if removed := m.RemoveExpired(); removed != 90 {
+		t.Errorf("want %d removed keys, got %d", 90, removed)
+	}
+
+	// The keys are kept when the underlying map is rebuilt.
+	for i := 0; i < 100; i += 10 {
+		if v, ok := m.Get(i); !ok || v != i {
+			t.Errorf("want key %d with value %d, got %d", i, i, v)
+		}
+	}
+
+	for i := 0; i < 100; i += 10 {
+		m.Delete(i)
+	}
+
+	if got := m.Len(); got != 0 {
+		t.Errorf("want length %d, got %d", 0, got)
+	}
+
+	if err := m.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMapSetRefreshAhead(t *testing.T) {
+	t.Parallel()
+
+	testTime := newMockTime(time.Now())
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.SetRefreshAhead("a", 1, time.Minute, time.Hour)
+	m.Set("b", 2, time.Minute) // Never stale.
+
+	if _, stale, ok := m.GetRefreshAhead("a"); !ok || stale {
+		t.Errorf("want fresh key, got stale %t (exists %t)", stale, ok)
+	}
+
+	testTime.Advance(30 * time.Minute)
+
+	if value, stale, ok := m.GetRefreshAhead("a"); !ok || !stale || value != 1 {
+		t.Errorf("want stale value %d, got %d (stale %t, exists %t)", 1, value, stale, ok)
+	}
+
+	if _, stale, ok := m.GetRefreshAhead("b"); ok || stale {
+		t.Errorf("want expired key b to be missing, got stale %t (exists %t)", stale, ok)
+	}
+
+	m.Update("a", 10) // Still stale.
+
+	if _, stale, _ := m.GetRefreshAhead("a"); !stale {
+		t.Error("want updated key to be stale")
+	}
+
+	m.Set("a", 100, time.Hour) // Refreshed.
+
+	if _, stale, ok := m.GetRefreshAhead("a"); !ok || stale {
+		t.Errorf("want refreshed key, got stale %t (exists %t)", stale, ok)
+	}
+
+	m.SetRefreshAhead("c", 3, time.Minute, time.Hour)
+	testTime.Advance(2 * time.Hour)
+
+	if _, _, ok := m.GetRefreshAhead("c"); ok {
+		t.Error("want key c to expire after the hard ttl")
+	}
+}
+
+func TestMapSetWithGrace(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+	})
+	defer m.Stop()
+
+	m.SetWithGrace("a", 1, time.Minute, time.Hour)
+
+	if value, stale, ok := m.GetStale("a"); !ok || stale || value != 1 {
+		t.Errorf("want fresh value %d, got %d (stale %t, ok %t)", 1, value, stale, ok)
+	}
+
+	testTime.Advance(2 * time.Minute)
+
+	if _, ok := m.Get("a"); ok {
+		t.Error("want expired key missing")
+	}
+
+	if value, stale, ok := m.GetStale("a"); !ok || !stale || value != 1 {
+		t.Errorf("want stale value %d, got %d (stale %t, ok %t)", 1, value, stale, ok)
+	}
+
+	if removed := m.RemoveExpired(); removed != 0 {
+		t.Errorf("want no removed keys during the grace period, got %d", removed)
+	}
+
+	testTime.Advance(time.Hour)
+
+	if _, _, ok := m.GetStale("a"); ok {
+		t.Error("want key missing after the grace period")
+	}
+
+	if removed := m.RemoveExpired(); removed != 1 {
+		t.Errorf("want %d removed key, got %d", 1, removed)
+	}
+}
+
+func TestNewWithEntries(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	seed := map[string]int{"a": 1, "b": 2}
+
+	m := xmap.NewWithEntries(xmap.Config{TimeSource: testTime}, seed, time.Minute)
+	defer m.Stop()
+
+	if got := maps.Collect(m.All()); !maps.Equal(seed, got) {
+		t.Errorf("want entries %v, got %v", seed, got)
+	}
+
+	if _, exp, _ := m.GetWithExpiration("a"); !exp.Equal(now.Add(time.Minute)) {
+		t.Errorf("want expiration %v, got %v", now.Add(time.Minute), exp)
+	}
+
+	// The seed is not retained.
+	seed["c"] = 3
+	if _, ok := m.Get("c"); ok {
+		t.Error("want seed map not retained")
+	}
+}
+
+func TestMapRangeDelete(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[int, int]()
+	defer m.Stop()
+
+	for i := range 10 {
+		m.Set(i, i, 0)
+	}
+
+	removed := m.RangeDelete(func(key, value int) (bool, bool) {
+		if key == 0 {
+			// Calling the map methods does not deadlock, the changed key is not deleted.
+			m.Update(0, 100)
+		}
+		return value%2 == 0, false
+	})
+
+	if removed != 4 {
+		t.Errorf("want %d removed keys, got %d", 4, removed)
+	}
+
+	want := map[int]int{0: 100, 1: 1, 3: 3, 5: 5, 7: 7, 9: 9}
+	if got := maps.Collect(m.All()); !maps.Equal(want, got) {
+		t.Errorf("want entries %v, got %v", want, got)
+	}
+
+	var calls int
+	m.RangeDelete(func(int, int) (bool, bool) {
+		calls++
+		return false, true
+	})
+
+	if calls != 1 {
+		t.Errorf("want %d call before stopping, got %d", 1, calls)
+	}
+}
+
+func TestMapOldestAndNewest(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	if _, _, ok := m.Oldest(); ok {
+		t.Error("want no oldest key in an empty map")
+	}
+
+	for i, key := range []string{"a", "b", "c"} {
+		m.Set(key, i, 0)
+		testTime.Advance(time.Second)
+	}
+
+	// Updating the value does not change the order.
+	m.Update("a", 10)
+
+	if key, value, ok := m.Oldest(); !ok || key != "a" || value != 10 {
+		t.Errorf("want oldest key %q with value %d, got %q with value %d", "a", 10, key, value)
+	}
+
+	if key, value, ok := m.Newest(); !ok || key != "c" || value != 2 {
+		t.Errorf("want newest key %q with value %d, got %q with value %d", "c", 2, key, value)
+	}
+}
+
+func TestMapSetSeq(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[int, int]()
+	defer m.Stop()
+
+	const count = 3000 // More than a single batch.
+
+	seq := func(yield func(int, int) bool) {
+		for i := range count {
+			if !yield(i, i*2) {
+				return
+			}
+		}
+	}
+
+	if n := m.SetSeq(seq, time.Hour); n != count {
+		t.Errorf("want %d keys set, got %d", count, n)
+	}
+
+	if got := m.Len(); got != count {
+		t.Errorf("want length %d, got %d", count, got)
+	}
+
+	if value, ok := m.Get(count - 1); !ok || value != (count-1)*2 {
+		t.Errorf("want value %d, got %d", (count-1)*2, value)
+	}
+}
+
+func TestMapGetOrCompute(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	errCompute := errors.New("compute failed")
+
+	if _, err := m.GetOrCompute("a", func() (int, time.Duration, error) {
+		return 1, time.Minute, errCompute
+	}); !errors.Is(err, errCompute) {
+		t.Errorf("want error %v, got %v", errCompute, err)
+	}
+
+	if _, ok := m.Get("a"); ok {
+		t.Error("want no value stored on error")
+	}
+
+	value, err := m.GetOrCompute("a", func() (int, time.Duration, error) {
+		return 2, time.Minute, nil
+	})
+	if err != nil || value != 2 {
+		t.Fatalf("want value %d, got %d (error %v)", 2, value, err)
+	}
+
+	if _, exp, _ := m.GetWithExpiration("a"); !exp.Equal(now.Add(time.Minute)) {
+		t.Errorf("want expiration %v, got %v", now.Add(time.Minute), exp)
+	}
+
+	// The stored value is returned without calling compute.
+	value, _ = m.GetOrCompute("a", func() (int, time.Duration, error) {
+		t.Error("compute called for an existing key")
+		return 0, 0, nil
+	})
+	if value != 2 {
+		t.Errorf("want value %d, got %d", 2, value)
+	}
+}
+
+func TestMapGetOrComputeSharedError(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, int]()
+	defer m.Stop()
+
+	errCompute := errors.New("compute failed")
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	compute := func() (int, time.Duration, error) {
+		calls.Add(1)
+		<-release
+		return 0, 0, errCompute
+	}
+
+	const goroutines = 5
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.GetOrCompute("a", compute)
+			errs <- err
+		}()
+	}
+
+	// Wait for the first call to start, then give time to the others to join it.
+	retryUntil(time.Second, func() bool { return calls.Load() == 1 })
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("want compute calls %d, got %d", 1, got)
+	}
+
+	for err := range errs {
+		if !errors.Is(err, errCompute) {
+			t.Errorf("want error %v, got %v", errCompute, err)
+		}
+	}
+}
+
+func TestMapInFlightCallsPerMethod(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, int]()
+	defer m.Stop()
+
+	errCompute := errors.New("compute failed")
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	computed := make(chan error)
+
+	// A failing compute call that is in-flight for the key.
+	go func() {
+		_, err := m.GetOrCompute("a", func() (int, time.Duration, error) {
+			close(started)
+			<-release
+			return 0, 0, errCompute
+		})
+		computed <- err
+	}()
+
+	<-started
+
+	// The other methods do not wait for the compute call nor receive its results.
+	value, err := m.GetOrLoad("a", 0, func() (int, error) { return 1, nil })
+	if err != nil || value != 1 {
+		t.Errorf("want loaded value %d, got %d (error %v)", 1, value, err)
+	}
+
+	m.Delete("a")
+
+	if value := m.Once("a", 0, func() int { return 2 }); value != 2 {
+		t.Errorf("want init value %d, got %d", 2, value)
+	}
+
+	close(release)
+
+	if err := <-computed; !errors.Is(err, errCompute) {
+		t.Errorf("want error %v, got %v", errCompute, err)
+	}
+
+	if value, ok := m.Get("a"); !ok || value != 2 {
+		t.Errorf("want value %d, got %d (exists %t)", 2, value, ok)
+	}
+}
+
+func TestMapApproxLen(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	m.Set("b", 2, time.Minute)
+	m.Set("c", 3, 0)
+	m.Set("a", 10, 0) // Replaced.
+
+	if got := m.ApproxLen(); got != 3 {
+		t.Errorf("want length %d, got %d", 3, got)
+	}
+
+	m.Delete("c")
+	testTime.Advance(2 * time.Minute)
+	m.RemoveExpired()
+
+	if got := m.ApproxLen(); got != 1 {
+		t.Errorf("want length %d, got %d", 1, got)
+	}
+
+	m.Clear()
+
+	if got := m.ApproxLen(); got != 0 {
+		t.Errorf("want length %d, got %d", 0, got)
+	}
+}
+
+func TestMapReset(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[int, int](xmap.Config{
+		InitialCapacity: 10,
+	})
+	defer m.Stop()
+
+	for i := range 100 {
+		m.Set(i, i, 0)
+	}
+	m.Get(0)
+	m.Get(-1)
+
+	m.Reset()
+
+	if got := m.Len(); got != 0 {
+		t.Errorf("want length %d, got %d", 0, got)
+	}
+
+	if got := m.Stats(); got != (xmap.Stats{Hits: 1, Misses: 1}) {
+		t.Errorf("want stats kept without the option, got %+v", got)
+	}
+
+	m.Set(1, 1, 0)
+	m.Reset(xmap.ResetShrink(), xmap.ResetCounters())
+
+	if got := m.Len(); got != 0 {
+		t.Errorf("want length %d, got %d", 0, got)
+	}
+
+	if got := m.Stats(); got != (xmap.Stats{}) {
+		t.Errorf("want zero stats, got %+v", got)
+	}
+
+	// The map is usable after the reset.
+	m.Set(1, 1, 0)
+	if v, ok := m.Get(1); !ok || v != 1 {
+		t.Errorf("want value %d, got %d", 1, v)
+	}
+}
+
+func TestMapTTLJitter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	jitter := 10 * time.Second
+
+	m := xmap.NewWithConfig[int, int](xmap.Config{
+		TimeSource: testTime,
+		TTLJitter:  jitter,
+		Rand:       rand.NewPCG(1, 2),
+	})
+	defer m.Stop()
+
+	for i := range 100 {
+		m.Set(i, i, time.Minute)
+	}
+	m.Set(-1, -1, 0)
+
+	distinct := make(map[time.Time]struct{})
+	for i := range 100 {
+		_, exp, _ := m.GetWithExpiration(i)
+		if exp.Before(now.Add(time.Minute-jitter)) || exp.After(now.Add(time.Minute+jitter)) {
+			t.Errorf("want expiration within the jitter, got %v", exp.Sub(now))
+		}
+		distinct[exp] = struct{}{}
+	}
+
+	if len(distinct) < 2 {
+		t.Error("want jittered expiration times")
+	}
+
+	if _, exp, _ := m.GetWithExpiration(-1); !exp.IsZero() {
+		t.Errorf("want key that never expires not affected, got %v", exp)
+	}
+}
+
+func TestMapSetAtUnix(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.SetAtUnix("a", 1, now.Unix()+60)
+	m.SetAtUnix("b", 2, 0)             // Never expires.
+	m.SetAtUnix("c", 3, -1)            // Never expires.
+	m.SetAtUnix("d", 4, now.Unix()-60) // Already expired, not set.
+
+	if _, exp, ok := m.GetWithExpiration("a"); !ok || !exp.Equal(now.Add(time.Minute)) {
+		t.Errorf("want key a expiration %v, got %v (exists %t)", now.Add(time.Minute), exp, ok)
+	}
+
+	for _, key := range []string{"b", "c"} {
+		if _, exp, ok := m.GetWithExpiration(key); !ok || !exp.IsZero() {
+			t.Errorf("want key %q to never expire, got expiration %v (exists %t)", key, exp, ok)
+		}
+	}
+
+	if _, ok := m.Get("d"); ok || m.Len() != 3 {
+		t.Errorf("want expired key d to not be set, got map length %d", m.Len())
+	}
+}
+
+func TestMapSetUntil(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+	})
+	defer m.Stop()
+
+	deadline := now.Add(time.Minute)
+	m.SetUntil("a", 1, deadline, time.Minute)
+
+	if _, exp, ok := m.GetWithExpiration("a"); !ok || !exp.Equal(deadline) {
+		t.Errorf("want expiration %v, got %v", deadline, exp)
+	}
+
+	testTime.Advance(90 * time.Second)
+
+	if _, ok := m.Get("a"); ok {
+		t.Error("want key missing after the deadline")
+	}
+
+	if _, stale, ok := m.GetStale("a"); !ok || !stale {
+		t.Error("want stale key served during the grace period")
+	}
+
+	if removed := m.RemoveExpired(); removed != 0 {
+		t.Errorf("want no removed keys during the grace period, got %d", removed)
+	}
+
+	testTime.Advance(time.Minute)
+
+	if removed := m.RemoveExpired(); removed != 1 {
+		t.Errorf("want %d removed key, got %d", 1, removed)
+	}
+
+	// A key past its deadline and grace period is not set.
+	m.SetUntil("b", 2, now, time.Second)
+	if got := m.Len(); got != 0 {
+		t.Errorf("want length %d, got %d", 0, got)
+	}
+}
+
+func TestMapKeysInScoreRange(t *testing.T) {
+	t.Parallel()
+
+	type player struct {
+		points int
+	}
+
+	m := xmap.New[string, player]()
+	defer m.Stop()
+
+	m.Set("a", player{10}, 0)
+	m.Set("b", player{20}, 0)
+	m.Set("c", player{30}, 0)
+	m.Set("d", player{40}, 0)
+
+	score := func(p player) float64 { return float64(p.points) }
+
+	got := m.KeysInScoreRange(score, 20, 30) // Inclusive bounds.
+	slices.Sort(got)
+
+	if want := []string{"b", "c"}; !slices.Equal(want, got) {
+		t.Errorf("want keys %v, got %v", want, got)
+	}
+
+	if got := m.KeysInScoreRange(score, 50, 60); len(got) != 0 {
+		t.Errorf("want no keys, got %v", got)
+	}
+}
+
+func TestMapExpireNow(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	m.SetWithGrace("b", 2, time.Hour, time.Hour)
+	m.Set("c", 3, time.Hour)
+
+	if n := m.ExpireNow([]string{"a", "b", "missing"}); n != 2 {
+		t.Errorf("want %d expired keys, got %d", 2, n)
+	}
+
+	if _, ok := m.Get("a"); ok {
+		t.Error("want expired key missing")
+	}
+
+	if value, stale, ok := m.GetStale("b"); !ok || !stale || value != 2 {
+		t.Errorf("want stale value %d during the grace period, got %d", 2, value)
+	}
+
+	if removed := m.RemoveExpired(); removed != 1 {
+		t.Errorf("want %d removed key, got %d", 1, removed)
+	}
+
+	if _, ok := m.Get("c"); !ok {
+		t.Error("want other keys not affected")
+	}
+}
+
+func TestMapGetOrLoadServeStaleOnError(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+		ServeStaleOnError:  true,
+		NegativeTTL:        time.Minute,
+	})
+	defer m.Stop()
+
+	errLoad := errors.New("load failed")
+	failing := func() (int, error) { return 0, errLoad }
+
+	m.SetWithGrace("a", 1, time.Minute, time.Hour)
+	testTime.Advance(2 * time.Minute)
+
+	value, err := m.GetOrLoad("a", time.Minute, failing)
+	if err != nil || value != 1 {
+		t.Errorf("want stale value %d without error, got %d (error %v)", 1, value, err)
+	}
+
+	// The error is not cached, the stale value is kept.
+	value, err = m.GetOrLoad("a", time.Minute, func() (int, error) { return 2, nil })
+	if err != nil || value != 2 {
+		t.Errorf("want loaded value %d, got %d (error %v)", 2, value, err)
+	}
+
+	// A missing key returns the error.
+	if _, err := m.GetOrLoad("b", time.Minute, failing); !errors.Is(err, errLoad) {
+		t.Errorf("want error %v, got %v", errLoad, err)
+	}
+}
+
+func TestMapGetOrLoadPerKeyConcurrency(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, int]()
+	defer m.Stop()
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	slow := func() (int, error) {
+		calls.Add(1)
+		<-release
+		return 1, nil
+	}
+
+	const goroutines = 5
+
+	var wg sync.WaitGroup
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := m.GetOrLoad("a", 0, slow); err != nil || v != 1 {
+				t.Errorf("want value %d, got %d (error %v)", 1, v, err)
+			}
+		}()
+	}
+
+	retryUntil(time.Second, func() bool { return calls.Load() == 1 })
+
+	// The slow load of a key does not block the loads of the other keys.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.GetOrLoad("b", 0, func() (int, error) { return 2, nil })
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("load of another key blocked by a slow loader")
+	}
+
+	time.Sleep(50 * time.Millisecond) // Give time to the other goroutines to join the load.
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("want loader calls %d, got %d", 1, got)
+	}
+}
+
+func TestMapGetManyOrLoad(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, int]()
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+
+	var requested []string
+	loader := func(ctx context.Context, missing []string) (map[string]int, error) {
+		requested = slices.Sorted(slices.Values(missing))
+		return map[string]int{"b": 2}, nil // Partial result.
+	}
+
+	got, err := m.GetManyOrLoad(context.Background(), []string{"a", "b", "c", "b"}, 0, loader)
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+
+	if want := []string{"b", "c"}; !slices.Equal(want, requested) {
+		t.Errorf("want loader keys %v, got %v", want, requested)
+	}
+
+	if want := map[string]int{"a": 1, "b": 2}; !maps.Equal(want, got) {
+		t.Errorf("want values %v, got %v", want, got)
+	}
+
+	if _, ok := m.Get("c"); ok {
+		t.Error("want key c not returned by the loader to not be set")
+	}
+
+	errLoad := errors.New("load failed")
+	got, err = m.GetManyOrLoad(context.Background(), []string{"a", "d"}, 0,
+		func(ctx context.Context, missing []string) (map[string]int, error) {
+			return nil, errLoad
+		})
+
+	if !errors.Is(err, errLoad) {
+		t.Errorf("want error %v, got %v", errLoad, err)
+	}
+
+	if want := map[string]int{"a": 1}; !maps.Equal(want, got) {
+		t.Errorf("want values %v, got %v", want, got)
+	}
+}
+
+func TestMapGetManyOrLoadInFlight(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, int]()
+	defer m.Stop()
+
+	loading := make(chan struct{})
+	release := make(chan struct{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.GetOrLoad("a", 0, func() (int, error) {
+			close(loading)
+			<-release
+			return 1, nil
+		})
+	}()
+
+	<-loading
+
+	var requested []string
+	result := make(chan map[string]int)
+	go func() {
+		got, _ := m.GetManyOrLoad(context.Background(), []string{"a", "b"}, 0,
+			func(ctx context.Context, missing []string) (map[string]int, error) {
+				requested = missing
+				return map[string]int{"b": 2}, nil
+			})
+		result <- got
+	}()
+
+	// The in-flight key is waited for until the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	partial, err := m.GetManyOrLoad(ctx, []string{"a", "c"}, 0,
+		func(ctx context.Context, missing []string) (map[string]int, error) {
+			return map[string]int{"c": 3}, nil
+		})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want error %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	// The keys loaded by the call are returned.
+	if want := map[string]int{"c": 3}; !maps.Equal(want, partial) {
+		t.Errorf("want values %v, got %v", want, partial)
+	}
+
+	close(release)
+	<-done
+
+	got := <-result
+	if want := map[string]int{"a": 1, "b": 2}; !maps.Equal(want, got) {
+		t.Errorf("want values %v, got %v", want, got)
+	}
+
+	if want := []string{"b"}; !slices.Equal(want, requested) {
+		t.Errorf("want loader keys %v, got %v", want, requested)
+	}
+}
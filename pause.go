@@ -0,0 +1,30 @@
+package xmap
+
+// PauseCleanup pauses the removal of the expired keys by the cleanup goroutine
+// (Including [Map.Run]) until [Map.ResumeCleanup] is called, for example to prevent
+// the keys from being removed during a bulk operation.
+//
+// The cleanup goroutine keeps running and checks an atomic flag on each tick, the
+// paused ticks are skipped entirely (Including the proactive refresh) and they are
+// not recorded as cleanup passes (See [Map.LastCleanup]). Unlike [Map.Stop], the
+// keys are kept and the [Map] remains usable.
+//
+// The expired keys are still treated as missing by the reads and they can be removed
+// by calling [Map.RemoveExpired], unless [Config.PauseExpiration] is enabled in which
+// case the expiration of all the keys is paused too.
+//
+// This method is safe to be called multiple times, the pause is not nested.
+func (m *Map[K, V]) PauseCleanup() {
+	m.paused.Store(true)
+}
+
+// ResumeCleanup resumes the cleanup paused by [Map.PauseCleanup], the keys that
+// expired while paused are removed by the next cleanup pass.
+func (m *Map[K, V]) ResumeCleanup() {
+	m.paused.Store(false)
+}
+
+// CleanupPaused reports whether the cleanup is paused (See [Map.PauseCleanup]).
+func (m *Map[K, V]) CleanupPaused() bool {
+	return m.paused.Load()
+}
@@ -0,0 +1,87 @@
+package xmap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapPauseCleanup(t *testing.T) {
+	t.Parallel()
+
+	testTime := newMockTime(time.Now())
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		CleanupInterval: time.Minute,
+		TimeSource:      testTime,
+	})
+	defer m.Stop()
+
+	if isActive := retryUntil(time.Second, m.CleanupActive); !isActive {
+		t.Fatal("cleanup loop did not start in time")
+	}
+
+	m.Set("a", 1, time.Minute)
+	m.Set("b", 2, 0)
+
+	m.PauseCleanup()
+	if !m.CleanupPaused() {
+		t.Error("want paused cleanup")
+	}
+
+	testTime.Advance(2 * time.Minute)
+
+	// The ticker channel is buffered, the first tick has been handled when the
+	// third tick is sent.
+	for range 3 {
+		testTime.Tick()
+	}
+
+	if m.Len() != 2 || !m.LastCleanup().IsZero() {
+		t.Errorf("want no cleanup while paused, got map length %d and last cleanup %v",
+			m.Len(), m.LastCleanup())
+	}
+
+	if _, ok := m.Get("a"); ok {
+		t.Error("want expired key to be missing")
+	}
+
+	m.ResumeCleanup()
+	testTime.Tick()
+
+	if removed := retryUntil(time.Second, func() bool { return m.Len() == 1 }); !removed {
+		t.Errorf("want expired key to be removed after resuming, got map length %d", m.Len())
+	}
+}
+
+func TestMapPauseExpiration(t *testing.T) {
+	t.Parallel()
+
+	testTime := newMockTime(time.Now())
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		PauseExpiration:    true,
+		DisableAutoCleanup: true,
+		TimeSource:         testTime,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, time.Minute)
+	m.PauseCleanup()
+	testTime.Advance(2 * time.Minute)
+
+	if value, ok := m.Get("a"); !ok || value != 1 {
+		t.Errorf("want expired key to be served while paused, got %d (exists %t)", value, ok)
+	}
+
+	if removed := m.RemoveExpired(); removed != 0 {
+		t.Errorf("want no removals while paused, got %d", removed)
+	}
+
+	m.ResumeCleanup()
+
+	if _, ok := m.Get("a"); ok {
+		t.Error("want expired key to be missing after resuming")
+	}
+}
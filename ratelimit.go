@@ -0,0 +1,102 @@
+package xmap
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token bucket rate limiter (See [Config.MaxWritesPerSec]).
+//
+// The bucket holds at most rate tokens (A burst of one second of writes), and it's
+// refilled continuously at rate tokens per second based on the times passed to take.
+type tokenBucket struct {
+	mu     sync.Mutex // Mutex to synchronize the bucket access.
+	rate   float64    // Tokens added per second, also the bucket capacity.
+	tokens float64    // Available tokens.
+	last   time.Time  // Time of the last refill.
+}
+
+// newTokenBucket creates a new full [tokenBucket] with the specified rate per second.
+func newTokenBucket(rate int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: now}
+}
+
+// take takes a token at the time now and reports whether a token was available,
+// if not it returns the time to wait until a token is available.
+func (b *tokenBucket) take(now time.Time) (ok bool, wait time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.rate, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// allowWrite reports whether a rate limited write is allowed (See [Config.MaxWritesPerSec]).
+//
+// If block is true and [Config.BlockRateLimited] is set, it waits until a token is
+// available or the [Map] is stopped, otherwise the write is dropped if there is no
+// token available. The wait uses a ticker of the [Config.TimeSource].
+//
+// It must be called without holding the lock.
+func (m *Map[K, V]) allowWrite(block bool) bool {
+	if m.limiter == nil {
+		return true
+	}
+
+	ok, wait := m.limiter.take(m.time.Now())
+	if ok {
+		return true
+	}
+
+	if !block || !m.blockRate {
+		return false
+	}
+
+	// The tokens are checked again on each tick of the time source.
+	ticker := m.time.NewTicker(max(wait, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-m.stop:
+			return false
+		}
+
+		if ok, _ := m.limiter.take(m.time.Now()); ok {
+			return true
+		}
+	}
+}
+
+// TrySet creates or replaces a key-value pair in the [Map] like [Map.Set] unless
+// the write is rate limited (See [Config.MaxWritesPerSec]).
+//
+// It never blocks waiting for the rate limit even if [Config.BlockRateLimited] is
+// set, the return value reports whether the key was set.
+func (m *Map[K, V]) TrySet(key K, value V, ttl time.Duration) bool {
+	entry := m.newRecord(value, ttl)
+
+	if !m.allowWrite(false) {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return false
+	}
+
+	m.merge(key, entry)
+	return m.store(key, entry)
+}
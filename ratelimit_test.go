@@ -0,0 +1,152 @@
+package xmap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapMaxWritesPerSec(t *testing.T) {
+	t.Parallel()
+
+	testTime := newMockTime(time.Now())
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		MaxWritesPerSec: 2,
+		TimeSource:      testTime,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	m.Set("b", 2, 0)
+	m.Set("c", 3, 0) // Dropped.
+
+	if m.Len() != 2 {
+		t.Errorf("want map length %d, got %d", 2, m.Len())
+	}
+
+	if m.TrySet("c", 3, 0) || m.Update("a", 10) {
+		t.Error("want rate limited writes to be dropped")
+	}
+
+	testTime.Advance(500 * time.Millisecond) // 1 token.
+
+	if !m.TrySet("c", 3, 0) {
+		t.Error("want write to be allowed after the refill")
+	}
+
+	if m.Update("a", 10) {
+		t.Error("want rate limited update to be dropped")
+	}
+
+	testTime.Advance(time.Hour) // Refilled up to the burst.
+
+	for i := range 2 {
+		if !m.Update("a", i) {
+			t.Errorf("want update %d to be allowed", i)
+		}
+	}
+}
+
+func TestMapBlockRateLimited(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		MaxWritesPerSec:  100,
+		BlockRateLimited: true,
+	})
+
+	start := time.Now()
+	for i := range 101 {
+		m.Set("a", i, 0)
+	}
+
+	if elapsed := time.Since(start); elapsed < 5*time.Millisecond {
+		t.Errorf("want the write over the burst to wait, took %v", elapsed)
+	}
+
+	if value, _ := m.Get("a"); value != 100 {
+		t.Errorf("want value %d, got %d", 100, value)
+	}
+
+	m.Stop()
+
+	// The blocked writes are released when the map is stopped.
+	slow := xmap.NewWithConfig[string, int](xmap.Config{
+		MaxWritesPerSec:  1,
+		BlockRateLimited: true,
+	})
+
+	slow.Set("a", 1, 0)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		slow.Stop()
+	}()
+
+	start = time.Now()
+	slow.Set("b", 2, 0)
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("want the blocked write to be released on stop, took %v", elapsed)
+	}
+}
+
+func TestMapBlockRateLimitedTimeSource(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		MaxWritesPerSec:    1,
+		BlockRateLimited:   true,
+		TimeSource:         testTime,
+		DisableAutoCleanup: true,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Set("b", 2, time.Second)
+	}()
+
+	// Wait for the blocked write to create its ticker.
+	waiting := retryUntil(time.Second, func() bool {
+		testTime.RLock()
+		defer testTime.RUnlock()
+		return len(testTime.tickers) == 1
+	})
+	if !waiting {
+		t.Fatal("want the write over the burst to wait")
+	}
+
+	select {
+	case <-done:
+		t.Fatal("want the write to block until the next tick")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// The write is allowed after waiting longer than its ttl.
+	testTime.Advance(2 * time.Second)
+	testTime.Tick()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("want the write to be released after the refill")
+	}
+
+	if value, ok := m.Get("b"); !ok || value != 2 {
+		t.Errorf("want value %d, got %d", 2, value)
+	}
+
+	// The ttl starts when the write is allowed.
+	if _, exp, _ := m.GetWithExpiration("b"); !exp.Equal(now.Add(3 * time.Second)) {
+		t.Errorf("want expiration %v, got %v", now.Add(3*time.Second), exp)
+	}
+}
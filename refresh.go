@@ -0,0 +1,105 @@
+package xmap
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// RefreshFunc is a function that returns a new value for a key that is about to
+// expire with the ttl of the new value (See [Config.ProactiveRefresh]).
+//
+// If an error is returned, the key is not changed and it's left to expire.
+type RefreshFunc[K comparable, V any] func(key K, value V) (V, time.Duration, error)
+
+// SetRefreshFunc sets the function used by the cleanup goroutine to refresh the keys
+// expiring within the [Config.ProactiveRefresh] window, a nil function disables
+// the refresh.
+func (m *Map[K, V]) SetRefreshFunc(fn RefreshFunc[K, V]) {
+	m.mu.Lock()
+	m.refresh = fn
+	m.mu.Unlock()
+}
+
+// refreshExpiring calls the refresh function for the live keys expiring within the
+// refresh window at the time now and replaces their values.
+//
+// The keys are refreshed by a bounded number of goroutines that are waited for
+// before returning, the pending refreshes are skipped if the [Map] is stopped.
+// A key is not replaced if it was changed during its refresh, and the members of a
+// group (See [Map.SetInGroup]) are not refreshed since they cannot outlive the group.
+func (m *Map[K, V]) refreshExpiring(now time.Time) {
+	type candidate struct {
+		key     K
+		value   V
+		version uint64
+	}
+
+	m.mu.RLock()
+	fn := m.refresh
+	var candidates []candidate
+	if fn != nil {
+		for key, entry := range m.kv.Range {
+			if entry.err == nil && entry.group() == 0 && !entry.exp.IsZero() &&
+				!m.expiredAt(entry, now) && entry.exp.Sub(now) <= m.window {
+				candidates = append(candidates, candidate{key, entry.value, entry.version})
+			}
+		}
+	}
+	m.mu.RUnlock()
+
+	if len(candidates) == 0 {
+		return
+	}
+
+	queue := make(chan candidate, len(candidates))
+	for _, c := range candidates {
+		queue <- c
+	}
+	close(queue)
+
+	var wg sync.WaitGroup
+
+	for range min(runtime.GOMAXPROCS(0), len(candidates)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range queue {
+				if m.Stopped() {
+					return
+				}
+
+				value, ttl, err := fn(c.key, c.value)
+				if err != nil {
+					continue
+				}
+
+				m.replaceVersion(c.key, c.version, value, ttl)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// replaceVersion replaces the value and the expiration time of the key only if its
+// current version matches the specified version, the creation time and the
+// expiration callback of the key are kept.
+func (m *Map[K, V]) replaceVersion(key K, version uint64, value V, ttl time.Duration) {
+	m.checkTTL(ttl)
+
+	now := m.time.Now()
+	entry := &Record[V]{value: value, modified: now}
+	entry.exp = m.expiration(now, ttl)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current, ok := m.kv.Get(key); ok && current.version == version && !m.frozen && !m.Stopped() {
+		entry.created = current.created
+		if onExpire := current.onExpire(); onExpire != nil {
+			entry.metadata().onExpire = onExpire
+		}
+		m.store(key, entry)
+	}
+}
@@ -0,0 +1,61 @@
+package xmap_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapProactiveRefresh(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		CleanupInterval:  time.Minute,
+		ProactiveRefresh: 10 * time.Minute,
+		TimeSource:       testTime,
+	})
+	defer m.Stop()
+
+	m.SetRefreshFunc(func(key string, value int) (int, time.Duration, error) {
+		if key == "fail" {
+			return 0, 0, errors.New("refresh failed")
+		}
+		return value + 1, time.Hour, nil
+	})
+
+	if isActive := retryUntil(time.Second, m.CleanupActive); !isActive {
+		t.Fatal("cleanup loop did not start in time")
+	}
+
+	m.Set("a", 1, 5*time.Minute)    // Expiring within the window.
+	m.Set("b", 1, time.Hour)        // Not expiring within the window.
+	m.Set("fail", 1, 5*time.Minute) // Refresh fails.
+
+	testTime.Advance(time.Minute)
+	testTime.Tick()
+
+	refreshed := retryUntil(time.Second, func() bool {
+		value, _ := m.Get("a")
+		return value == 2
+	})
+	if !refreshed {
+		t.Fatal("key was not refreshed")
+	}
+
+	if _, exp, _ := m.GetWithExpiration("a"); !exp.Equal(now.Add(time.Minute + time.Hour)) {
+		t.Errorf("want refreshed expiration %v, got %v", now.Add(time.Minute+time.Hour), exp)
+	}
+
+	if value, _ := m.Get("b"); value != 1 {
+		t.Errorf("want key outside the window not refreshed, got value %d", value)
+	}
+
+	if value, _, _ := m.GetWithExpiration("fail"); value != 1 {
+		t.Errorf("want failed refresh to keep the value, got %d", value)
+	}
+}
@@ -0,0 +1,36 @@
+package xmap
+
+// ReplaceFunc is a function that returns the value to store when a live key is
+// overwritten, given the old and the new values of the key (See [Map.SetOnReplace]).
+type ReplaceFunc[K comparable, V any] func(key K, old, new V) V
+
+// SetOnReplace sets the function called when [Map.Set], [Map.SetR] or
+// [Map.SetReturning] overwrites a live key, the value returned by the function is
+// stored instead of the new value, which allows merging the old and the new values.
+// A nil function disables the hook, in which case the new value replaces the old
+// value.
+//
+// The function is called while holding the write lock, so the merge is atomic
+// with the write but the function must be fast and it must not call the [Map]
+// methods. It's not called for the missing, expired or negative keys, and the
+// other write methods are not affected.
+func (m *Map[K, V]) SetOnReplace(fn ReplaceFunc[K, V]) {
+	m.mu.Lock()
+	m.onReplace = fn
+	m.mu.Unlock()
+}
+
+// merge sets the value of the new record of the key using the replace function
+// if the key has a live record, the key is not looked up if there is no replace
+// function.
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) merge(key K, entry *Record[V]) {
+	if m.onReplace == nil {
+		return
+	}
+
+	if old, ok := m.kv.Get(key); ok && m.live(old) {
+		entry.value = m.onReplace(key, old.value, entry.value)
+	}
+}
@@ -0,0 +1,61 @@
+package xmap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapSetOnReplace(t *testing.T) {
+	t.Parallel()
+
+	testTime := newMockTime(time.Now())
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	var calls int
+	m.SetOnReplace(func(key string, old, new int) int {
+		calls++
+		return old + new
+	})
+
+	m.Set("a", 1, 0)  // Inserted.
+	m.Set("a", 2, 0)  // Merged.
+	m.SetR("a", 3, 0) // Merged.
+
+	if value, _ := m.Get("a"); value != 6 {
+		t.Errorf("want merged value %d, got %d", 6, value)
+	}
+
+	// Merged, the value before the merge is returned.
+	if prev, _, existed := m.SetReturning("a", 4, 0); !existed || prev != 6 {
+		t.Errorf("want previous value %d, got %d (existed %t)", 6, prev, existed)
+	}
+
+	if value, _ := m.Get("a"); value != 10 {
+		t.Errorf("want merged value %d, got %d", 10, value)
+	}
+
+	m.Set("b", 1, time.Minute)
+	testTime.Advance(2 * time.Minute)
+	m.Set("b", 10, 0) // Expired, not merged.
+
+	if value, _ := m.Get("b"); value != 10 {
+		t.Errorf("want value %d, got %d", 10, value)
+	}
+
+	if calls != 3 {
+		t.Errorf("want replace function calls %d, got %d", 3, calls)
+	}
+
+	m.SetOnReplace(nil)
+	m.Set("a", 1, 0)
+
+	if value, _ := m.Get("a"); value != 1 {
+		t.Errorf("want replaced value %d, got %d", 1, value)
+	}
+}
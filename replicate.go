@@ -0,0 +1,111 @@
+package xmap
+
+import (
+	"context"
+	"time"
+)
+
+// Replicate mirrors the live keys of the src [Map] into the [Map] every interval
+// until the context is done or one of the maps is stopped.
+//
+// On each pass the live entries of src are copied with their versions under its
+// read lock, then the new and the changed keys (Values or expiration times) are
+// set in the [Map] with their values and their absolute expiration times, along
+// with the replicated keys that were changed or removed in the [Map], and the
+// replicated keys that are no longer live in src (Deleted or expired) are deleted,
+// all under a single write lock of the [Map]. The two locks are never held at the same time.
+//
+// The replication is eventually consistent: the [Map] reflects the state of src at
+// the time of the last pass, so the changes are visible after at most one interval,
+// and the intermediate changes between two passes are not replicated. The changes
+// of src always replace the keys of the [Map], and the replicated keys that are
+// changed in the [Map] are restored on the next pass, but only the keys that still
+// hold the replicated values are deleted, so a replicated key that is overwritten
+// in the [Map] is kept when it's removed from src.
+//
+// The first pass runs immediately, the next passes are run using a ticker of the
+// [Config.TimeSource]. It returns the context error if the context is done, or
+// nil if one of the maps is stopped.
+func (m *Map[K, V]) Replicate(ctx context.Context, src *Map[K, V], interval time.Duration) error {
+	if src == m {
+		panic("xmap: a map cannot replicate itself")
+	}
+
+	ticker := m.time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// The versions of the replicated keys in src and in the [Map].
+	replicas := make(map[K]replica)
+
+	for {
+		m.replicate(src, replicas)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-m.stop:
+			return nil
+		case <-src.stop:
+			return nil
+		case <-ticker.C():
+		}
+	}
+}
+
+// replica is the state of a key replicated by [Map.Replicate].
+type replica struct {
+	src uint64    // Version of the key in the source map.
+	dst uint64    // Version of the key in the destination map.
+	exp time.Time // Expiration time of the key in the source map.
+}
+
+// replicate runs a single replication pass of [Map.Replicate].
+func (m *Map[K, V]) replicate(src *Map[K, V], replicas map[K]replica) {
+	type snapshot struct {
+		value   V
+		exp     time.Time
+		version uint64
+	}
+
+	src.mu.RLock()
+	entries := make(map[K]snapshot, src.kv.Len())
+	for key, entry := range src.kv.Range {
+		if src.live(entry) {
+			entries[key] = snapshot{entry.value, entry.exp, entry.version}
+		}
+	}
+	src.mu.RUnlock()
+
+	now := m.time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return
+	}
+
+	for key, r := range replicas {
+		if _, ok := entries[key]; ok {
+			continue
+		}
+
+		if entry, ok := m.kv.Get(key); ok && entry.version == r.dst {
+			m.remove(key)
+		}
+		delete(replicas, key)
+	}
+
+	for key, e := range entries {
+		// The expiration time may change without a version change (e.g. [RenewLease]).
+		if r, ok := replicas[key]; ok && r.src == e.version && r.exp.Equal(e.exp) {
+			if entry, ok := m.kv.Get(key); ok && entry.version == r.dst {
+				continue
+			}
+		}
+
+		entry := &Record[V]{value: e.value, exp: e.exp, created: now, modified: now}
+		m.store(key, entry)
+		replicas[key] = replica{src: e.version, dst: entry.version, exp: e.exp}
+	}
+}
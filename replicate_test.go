@@ -0,0 +1,129 @@
+package xmap_test
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapReplicate(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	src := xmap.NewWithConfig[string, int](xmap.Config{TimeSource: testTime})
+	defer src.Stop()
+
+	dst := xmap.NewWithConfig[string, int](xmap.Config{TimeSource: testTime})
+	defer dst.Stop()
+
+	src.Set("a", 1, time.Hour)
+	src.Set("b", 2, 0)
+	src.Set("c", 3, 0)
+	dst.Set("local", 0, 0) // Not replicated, never deleted.
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- dst.Replicate(ctx, src, time.Minute)
+	}()
+
+	replicated := func(want map[string]int) bool {
+		return retryUntil(time.Second, func() bool {
+			return maps.Equal(want, maps.Collect(dst.All()))
+		})
+	}
+
+	if want := map[string]int{"a": 1, "b": 2, "c": 3, "local": 0}; !replicated(want) {
+		t.Fatalf("want %v, got %v", want, maps.Collect(dst.All()))
+	}
+
+	if _, exp, _ := dst.GetWithExpiration("a"); !exp.Equal(now.Add(time.Hour)) {
+		t.Errorf("want replicated expiration %v, got %v", now.Add(time.Hour), exp)
+	}
+
+	src.Set("a", 10, 0)
+	src.Delete("b")
+	src.Delete("c")
+	dst.Set("c", 30, 0) // Changed locally, kept.
+
+	testTime.Tick()
+
+	if want := map[string]int{"a": 10, "c": 30, "local": 0}; !replicated(want) {
+		t.Errorf("want %v, got %v", want, maps.Collect(dst.All()))
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("want error %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestMapReplicateExpirationChange(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	src := xmap.NewWithConfig[string, string](xmap.Config{TimeSource: testTime, DisableAutoCleanup: true})
+	defer src.Stop()
+
+	dst := xmap.NewWithConfig[string, string](xmap.Config{TimeSource: testTime, DisableAutoCleanup: true})
+	defer dst.Stop()
+
+	if !xmap.AcquireLease(src, "lock", "owner", time.Minute) {
+		t.Fatal("want lease to be acquired")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- dst.Replicate(ctx, src, 30*time.Second)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	replicatedUntil := func(want time.Time) bool {
+		return retryUntil(time.Second, func() bool {
+			_, exp, ok := dst.GetWithExpiration("lock")
+			return ok && exp.Equal(want)
+		})
+	}
+
+	if !replicatedUntil(now.Add(time.Minute)) {
+		t.Fatal("want lease to be replicated")
+	}
+
+	// Renewed in place without a version change.
+	testTime.Set(now.Add(30 * time.Second))
+	if !xmap.RenewLease(src, "lock", "owner", time.Minute) {
+		t.Fatal("want lease to be renewed")
+	}
+
+	testTime.Tick()
+
+	if want := now.Add(90 * time.Second); !replicatedUntil(want) {
+		_, exp, _ := dst.GetWithExpiration("lock")
+		t.Fatalf("want replicated expiration %v, got %v", want, exp)
+	}
+
+	testTime.Set(now.Add(time.Minute + time.Second))
+	if _, ok := dst.Get("lock"); !ok {
+		t.Error("want renewed lease to be live after the first TTL")
+	}
+
+	// Removed locally, restored on the next pass.
+	dst.Delete("lock")
+	testTime.Tick()
+
+	if !replicatedUntil(now.Add(90 * time.Second)) {
+		t.Error("want removed key to be restored")
+	}
+}
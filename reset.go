@@ -0,0 +1,59 @@
+package xmap
+
+// ResetOption configures the state reset by [Map.Reset] in addition to the entries.
+type ResetOption func(*resetOptions)
+
+// resetOptions is the state reset by [Map.Reset].
+type resetOptions struct {
+	shrink bool // Release the memory of the underlying map.
+	stats  bool // Reset the lookup counters.
+}
+
+// ResetShrink makes [Map.Reset] rebuild the underlying map with the configured
+// [Config.InitialCapacity], releasing the memory of the grown map.
+//
+// It's only supported by the default in-memory [Backend].
+func ResetShrink() ResetOption {
+	return func(o *resetOptions) {
+		o.shrink = true
+	}
+}
+
+// ResetCounters makes [Map.Reset] set the lookup counters (See [Map.Stats]) to zero.
+func ResetCounters() ResetOption {
+	return func(o *resetOptions) {
+		o.stats = true
+	}
+}
+
+// Reset removes all the entries from the [Map] like [Map.Clear], and resets the
+// additional state selected by the options:
+//   - [ResetShrink]: the memory of the underlying map is released.
+//   - [ResetCounters]: the lookup counters are set to zero.
+//
+// The configuration, the cleanup goroutine and the frozen state are not affected,
+// so the [Map] starts fresh without being recreated.
+func (m *Map[K, V]) Reset(opts ...ResetOption) {
+	var o resetOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.writable() {
+		return
+	}
+
+	m.clear()
+
+	if o.shrink {
+		m.shrink(m.capacity)
+	}
+
+	if o.stats {
+		m.hits.Store(0)
+		m.misses.Store(0)
+	}
+}
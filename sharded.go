@@ -0,0 +1,342 @@
+package xmap
+
+import (
+	"hash/maphash"
+	"iter"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sharded is a thread-safe map with automatic key expiration that splits the
+// keys into multiple [Map] shards to reduce the lock contention.
+//
+// Each shard has its own lock, the keys are assigned to the shards by hashing.
+// A single background goroutine removes the expired keys of all the shards.
+type Sharded[K comparable, V any] struct {
+	shards   []*Map[K, V]              // The map shards.
+	seed     maphash.Seed              // Hash seed used to select a shard.
+	interval time.Duration             // Cleanup interval.
+	time     Time                      // Time source.
+	epoch    time.Time                 // Creation time of the map.
+	overrun  func(lag time.Duration)   // Cleanup overrun hook.
+	window   time.Duration             // Proactive refresh window.
+	stop     chan struct{}             // Channel closed on stop.
+	active   atomic.Int32              // Cleanup active flag.
+	stopped  atomic.Int32              // Map stopped flag.
+	last     atomic.Pointer[time.Time] // Time of the last cleanup pass.
+}
+
+// NewSharded creates a new [Sharded] map instance with the specified number of
+// shards and configuration.
+//
+// The [Config.InitialCapacity] is divided between the shards.
+// The number of shards is set to 1 if it's less than 1.
+//
+// The cleanup options apply to each shard on every pass of the cleanup goroutine
+// ([Config.ShrinkOnCleanup], [Config.ProactiveRefresh]), except the
+// [Config.OnCleanupOverrun] function that is called once per pass of all the shards.
+// The [Config.MaxWritesPerSec] rate limit applies to the writes of all the shards.
+func NewSharded[K comparable, V any](shards int, cfg Config) *Sharded[K, V] {
+	cfg.setDefaults()
+
+	shards = max(shards, 1)
+
+	// The shards are cleaned up by the sharded map goroutine.
+	shardCfg := cfg
+	shardCfg.DisableAutoCleanup = true
+	shardCfg.InitialCapacity = cfg.InitialCapacity / shards
+	shardCfg.OnCleanupOverrun = nil
+	shardCfg.MaxWritesPerSec = 0
+
+	s := &Sharded[K, V]{
+		shards:   make([]*Map[K, V], shards),
+		seed:     maphash.MakeSeed(),
+		interval: cfg.CleanupInterval,
+		time:     cfg.TimeSource,
+		epoch:    cfg.TimeSource.Now(),
+		overrun:  cfg.OnCleanupOverrun,
+		window:   cfg.ProactiveRefresh,
+		stop:     make(chan struct{}),
+	}
+
+	// The shards share a single rate limiter.
+	var limiter *tokenBucket
+	if cfg.MaxWritesPerSec > 0 {
+		limiter = newTokenBucket(cfg.MaxWritesPerSec, cfg.TimeSource.Now())
+	}
+
+	for i := range s.shards {
+		s.shards[i] = NewWithConfig[K, V](shardCfg)
+		s.shards[i].limiter = limiter
+	}
+
+	if !cfg.DisableAutoCleanup {
+		go s.cleanup()
+	}
+
+	return s
+}
+
+// NewShardedAuto creates a new [Sharded] map instance with the specified configuration
+// and a number of shards chosen from the current GOMAXPROCS value.
+//
+// The number of shards is the smallest power of two greater than or equal to
+// GOMAXPROCS, it can be retrieved using [Sharded.Shards].
+// Use [NewSharded] to set the number of shards explicitly.
+func NewShardedAuto[K comparable, V any](cfg Config) *Sharded[K, V] {
+	return NewSharded[K, V](autoShards(runtime.GOMAXPROCS(0)), cfg)
+}
+
+// autoShards returns the smallest power of two greater than or equal to procs.
+func autoShards(procs int) int {
+	shards := 1
+	for shards < procs {
+		shards <<= 1
+	}
+	return shards
+}
+
+// Shards returns the number of shards.
+func (s *Sharded[K, V]) Shards() int {
+	return len(s.shards)
+}
+
+// shard returns the shard of the key.
+func (s *Sharded[K, V]) shard(key K) *Map[K, V] {
+	h := hashKey(s.seed, key)
+	return s.shards[h%uint64(len(s.shards))]
+}
+
+// Stop halts the background cleanup goroutine and clears the shards.
+// It should be called when the [Sharded] map is no longer needed.
+//
+// This method is safe to be called multiple times.
+func (s *Sharded[K, V]) Stop() {
+	if s.stopped.CompareAndSwap(0, 1) {
+		close(s.stop)
+
+		for _, shard := range s.shards {
+			shard.Stop()
+		}
+	}
+}
+
+// Stopped reports whether the [Sharded] map is stopped.
+func (s *Sharded[K, V]) Stopped() bool {
+	return s.stopped.Load() == 1
+}
+
+// Len returns the total length of the shards.
+//
+// Similar to [Map.Len], the expired keys that have not been removed yet are included.
+func (s *Sharded[K, V]) Len() int {
+	var n int
+	for _, shard := range s.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Set creates or replaces a key-value pair.
+//
+// A key can be set to never expire with a ttl value of 0.
+func (s *Sharded[K, V]) Set(key K, value V, ttl time.Duration) {
+	s.shard(key).Set(key, value, ttl)
+}
+
+// Update changes the value of the key while preserving the expiration time.
+//
+// The return value reports whether there was an update (Key exists).
+func (s *Sharded[K, V]) Update(key K, value V) bool {
+	return s.shard(key).Update(key, value)
+}
+
+// Get returns the value associated with the key.
+//
+// The second bool return value reports whether the key exists.
+func (s *Sharded[K, V]) Get(key K) (V, bool) {
+	return s.shard(key).Get(key)
+}
+
+// GetWithExpiration returns the value and expiration time of the key.
+//
+// The third bool return value reports whether the key exists.
+func (s *Sharded[K, V]) GetWithExpiration(key K) (V, time.Time, bool) {
+	return s.shard(key).GetWithExpiration(key)
+}
+
+// All returns an iterator over key-value pairs from all the shards.
+//
+// The shards are iterated one at a time, only the read lock of the current
+// shard is held during the iteration.
+func (s *Sharded[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for _, shard := range s.shards {
+			for key, value := range shard.All() {
+				if !yield(key, value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Delete removes a key.
+func (s *Sharded[K, V]) Delete(key K) {
+	s.shard(key).Delete(key)
+}
+
+// Clear removes all the entries from all the shards.
+func (s *Sharded[K, V]) Clear() {
+	for _, shard := range s.shards {
+		shard.Clear()
+	}
+}
+
+// SetRefreshFunc sets the function used by the cleanup goroutine to refresh the keys
+// of all the shards expiring within the [Config.ProactiveRefresh] window, a nil
+// function disables the refresh (See [Map.SetRefreshFunc]).
+func (s *Sharded[K, V]) SetRefreshFunc(fn RefreshFunc[K, V]) {
+	for _, shard := range s.shards {
+		shard.SetRefreshFunc(fn)
+	}
+}
+
+// cleanup removes expired keys from the shards in an interval.
+//
+// The shards are cleaned up in parallel using a worker per CPU, each shard is
+// rebuilt after its sweep if needed (See [Config.ShrinkOnCleanup]). The overrun
+// hook is called after the sweep of all the shards, then the expiring keys of
+// the shards are refreshed (See [Config.ProactiveRefresh]).
+func (s *Sharded[K, V]) cleanup() {
+	ticker := s.time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	// Set as active.
+	s.active.Store(1)
+	defer s.active.Store(0)
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C():
+			workers := runtime.GOMAXPROCS(0)
+
+			start := s.time.Now()
+			s.parallel(workers, func(shard *Map[K, V]) int {
+				shard.sweep()
+				return 0
+			})
+			end := s.time.Now()
+			s.last.Store(&end)
+
+			if s.overrun != nil {
+				if elapsed := end.Sub(start); elapsed > s.interval {
+					s.overrun(elapsed - s.interval)
+				}
+			}
+
+			if s.window > 0 {
+				s.parallel(workers, func(shard *Map[K, V]) int {
+					shard.refreshExpiring(shard.time.Now())
+					return 0
+				})
+			}
+		}
+	}
+}
+
+// CleanupActive reports whether the cleanup goroutine is active.
+func (s *Sharded[K, V]) CleanupActive() bool {
+	return s.active.Load() == 1
+}
+
+// LastCleanup returns the time of the last cleanup pass of all the shards by the
+// cleanup goroutine, a zero time value is returned if no cleanup pass has
+// completed yet (See [Map.LastCleanup]).
+func (s *Sharded[K, V]) LastCleanup() time.Time {
+	if last := s.last.Load(); last != nil {
+		return *last
+	}
+	return time.Time{}
+}
+
+// Health returns a summary of the state of all the shards (See [Map.Health]).
+//
+// The keys of each shard are counted while holding its read lock.
+func (s *Sharded[K, V]) Health() HealthStatus {
+	status := HealthStatus{
+		CleanupActive: s.CleanupActive(),
+		Stopped:       s.Stopped(),
+	}
+
+	for _, shard := range s.shards {
+		h := shard.Health()
+		status.Live += h.Live
+		status.Expired += h.Expired
+	}
+
+	since := s.epoch
+	if status.LastCleanup = s.LastCleanup(); !status.LastCleanup.IsZero() {
+		since = status.LastCleanup
+	}
+	status.SinceLastCleanup = s.time.Now().Sub(since)
+
+	return status
+}
+
+// RemoveExpired removes the expired keys of the shards one shard at a time.
+//
+// It returns the number of keys that were removed.
+func (s *Sharded[K, V]) RemoveExpired() int {
+	var removed int
+	for _, shard := range s.shards {
+		removed += shard.RemoveExpired()
+	}
+	return removed
+}
+
+// RemoveExpiredParallel removes the expired keys of the shards in parallel
+// using a pool of workers, each shard is locked independently.
+//
+// The number of workers is limited to the number of shards, and it's set to
+// 1 if it's less than 1.
+//
+// It returns the total number of keys that were removed.
+func (s *Sharded[K, V]) RemoveExpiredParallel(workers int) int {
+	return s.parallel(workers, (*Map[K, V]).RemoveExpired)
+}
+
+// parallel calls fn for each shard using a pool of workers like
+// [Sharded.RemoveExpiredParallel] and returns the sum of the returned values.
+func (s *Sharded[K, V]) parallel(workers int, fn func(*Map[K, V]) int) int {
+	workers = min(max(workers, 1), len(s.shards))
+
+	shards := make(chan *Map[K, V], len(s.shards))
+	for _, shard := range s.shards {
+		shards <- shard
+	}
+	close(shards)
+
+	var (
+		wg      sync.WaitGroup
+		removed atomic.Int64
+	)
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range shards {
+				removed.Add(int64(fn(shard)))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return int(removed.Load())
+}
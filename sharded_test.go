@@ -0,0 +1,277 @@
+package xmap_test
+
+import (
+	"maps"
+	"math"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestShardedSetThenGet(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewSharded[int, int](8, xmap.Config{})
+	defer m.Stop()
+
+	if m.Shards() != 8 {
+		t.Fatalf("want %d shards, got %d", 8, m.Shards())
+	}
+
+	want := make(map[int]int)
+	for i := range 100 {
+		m.Set(i, i*10, time.Hour)
+		want[i] = i * 10
+	}
+
+	if m.Len() != 100 {
+		t.Fatalf("want map length %d, got %d", 100, m.Len())
+	}
+
+	for k, v := range want {
+		if got, ok := m.Get(k); !ok || got != v {
+			t.Errorf("want value %d for key %d, got %d", v, k, got)
+		}
+	}
+
+	got := maps.Collect(m.All())
+	if !maps.Equal(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	m.Delete(0)
+	if _, ok := m.Get(0); ok {
+		t.Errorf("key %d was not removed from the map", 0)
+	}
+
+	m.Clear()
+	if m.Len() != 0 {
+		t.Errorf("want map length %d, got %d", 0, m.Len())
+	}
+}
+
+func TestShardedComparableKeys(t *testing.T) {
+	t.Parallel()
+
+	type point struct {
+		X, Y float64
+		_    int
+	}
+
+	m := xmap.NewSharded[any, int](64, xmap.Config{})
+	defer m.Stop()
+
+	x := new(int)
+	negZero := math.Copysign(0, -1)
+
+	m.Set(point{X: 0, Y: 1}, 1, time.Hour)
+	m.Set([2]string{"a", "b"}, 2, time.Hour)
+	m.Set(x, 3, time.Hour)
+	m.Set(0.0, 4, time.Hour)
+
+	cases := []struct {
+		key  any
+		want int
+	}{
+		{point{X: negZero, Y: 1}, 1},
+		{[2]string{"a", "b"}, 2},
+		{x, 3},
+		{negZero, 4},
+	}
+
+	for _, tc := range cases {
+		if got, ok := m.Get(tc.key); !ok || got != tc.want {
+			t.Errorf("want value %d for key %v, got %d (ok %v)", tc.want, tc.key, got, ok)
+		}
+	}
+
+	if _, ok := m.Get(new(int)); ok {
+		t.Error("want a different pointer to not be found")
+	}
+}
+
+func TestShardedAuto(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewShardedAuto[int, int](xmap.Config{})
+	defer m.Stop()
+
+	procs, shards := runtime.GOMAXPROCS(0), m.Shards()
+
+	// Smallest power of two >= GOMAXPROCS.
+	if shards&(shards-1) != 0 || shards < procs || (shards > 1 && shards/2 >= procs) {
+		t.Errorf("want the smallest power of two >= %d shards, got %d", procs, shards)
+	}
+}
+
+func TestShardedRemoveExpiredParallel(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewSharded[int, int](8, xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	for i := range 100 {
+		ttl := time.Minute
+		if i%2 == 0 {
+			ttl = 0 // Never expires.
+		}
+		m.Set(i, i, ttl)
+	}
+
+	testTime.Advance(2 * time.Minute)
+
+	if removed := m.RemoveExpiredParallel(4); removed != 50 {
+		t.Errorf("want %d key removals, got %d", 50, removed)
+	}
+
+	if m.Len() != 50 {
+		t.Errorf("want map length %d, got %d", 50, m.Len())
+	}
+}
+
+func TestShardedBackgroundCleanup(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewSharded[int, int](4, xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	// Wait until the cleanup goroutine is active.
+	if isActive := retryUntil(time.Second, m.CleanupActive); !isActive {
+		t.Fatal("cleanup goroutine did not start in time")
+	}
+
+	for i := range 10 {
+		m.Set(i, i, time.Minute)
+	}
+
+	testTime.Advance(2 * time.Minute)
+	testTime.Tick()
+
+	if removed := retryUntil(time.Second, func() bool {
+		return m.Len() == 0
+	}); !removed {
+		t.Errorf("want map length %d, got %d", 0, m.Len())
+	}
+}
+
+func TestShardedCleanupOptions(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewSharded[int, int](4, xmap.Config{
+		CleanupInterval:  time.Minute,
+		ProactiveRefresh: 10 * time.Minute,
+		ShrinkOnCleanup:  true,
+		TimeSource:       testTime,
+	})
+	defer m.Stop()
+
+	m.SetRefreshFunc(func(key int, value int) (int, time.Duration, error) {
+		return value + 1, time.Hour, nil
+	})
+
+	if isActive := retryUntil(time.Second, m.CleanupActive); !isActive {
+		t.Fatal("cleanup goroutine did not start in time")
+	}
+
+	if last := m.LastCleanup(); !last.IsZero() {
+		t.Errorf("want zero last cleanup time before the first pass, got %v", last)
+	}
+
+	for i := range 10 {
+		m.Set(i, i, time.Minute) // Expired on the first pass.
+	}
+	m.Set(100, 1, 5*time.Minute) // Expiring within the refresh window.
+
+	testTime.Advance(2 * time.Minute)
+	testTime.Tick()
+
+	refreshed := retryUntil(time.Second, func() bool {
+		value, _ := m.Get(100)
+		return value == 2
+	})
+	if !refreshed {
+		t.Fatal("key was not refreshed")
+	}
+
+	if last := m.LastCleanup(); !last.Equal(now.Add(2 * time.Minute)) {
+		t.Errorf("want last cleanup time %v, got %v", now.Add(2*time.Minute), last)
+	}
+
+	status := m.Health()
+	if !status.CleanupActive || status.Live != 1 || status.Expired != 0 || status.SinceLastCleanup != 0 {
+		t.Errorf("unexpected health status %+v", status)
+	}
+}
+
+func TestShardedOnCleanupOverrun(t *testing.T) {
+	t.Parallel()
+
+	lags := make(chan time.Duration, 1)
+
+	// Every sweep takes longer than the interval.
+	m := xmap.NewSharded[int, int](4, xmap.Config{
+		CleanupInterval: time.Nanosecond,
+		OnCleanupOverrun: func(lag time.Duration) {
+			select {
+			case lags <- lag:
+			default:
+			}
+		},
+	})
+	defer m.Stop()
+
+	select {
+	case lag := <-lags:
+		if lag <= 0 {
+			t.Errorf("want positive lag, got %v", lag)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("overrun hook not called")
+	}
+}
+
+func TestShardedMaxWritesPerSec(t *testing.T) {
+	t.Parallel()
+
+	testTime := newMockTime(time.Now())
+
+	m := xmap.NewSharded[int, int](8, xmap.Config{
+		MaxWritesPerSec: 10,
+		TimeSource:      testTime,
+	})
+	defer m.Stop()
+
+	for i := range 80 {
+		m.Set(i, i, 0)
+	}
+
+	// The limit is shared by the shards.
+	if m.Len() != 10 {
+		t.Errorf("want map length %d, got %d", 10, m.Len())
+	}
+
+	testTime.Advance(time.Second)
+
+	for i := range 80 {
+		m.Set(100+i, i, 0)
+	}
+
+	if m.Len() != 20 {
+		t.Errorf("want map length %d after the refill, got %d", 20, m.Len())
+	}
+}
@@ -0,0 +1,30 @@
+package xmap
+
+// SetSpillTo sets the secondary [Map] to which the live keys removed by [Map.Trim]
+// are moved instead of being dropped, a nil spill map disables the spillover.
+//
+// The keys are moved with their values and their absolute expiration times, so
+// they keep their remaining TTLs, the keys that expire before being moved are
+// skipped (See [Map.SetEntries]). The expired keys and the cached loader errors
+// removed by [Map.Trim] are never moved.
+//
+// If fallback is true, [Map.Get] looks up the keys that are missing in the [Map]
+// in the spill map, in which case the value is returned from the spill map without
+// being moved back. Only the spill map itself is looked up, not its own spill map,
+// and the other read methods only look up the keys in the [Map].
+//
+// The spill map is written and read without holding the lock of the [Map], so
+// the maps can spill to each other, but a [Map] cannot spill to itself.
+func (m *Map[K, V]) SetSpillTo(spill *Map[K, V], fallback bool) {
+	if spill == m {
+		panic("xmap: a map cannot spill to itself")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.spill, m.fallback = spill, nil
+	if fallback {
+		m.fallback = spill
+	}
+}
@@ -0,0 +1,55 @@
+package xmap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapSetSpillTo(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	cold := xmap.NewWithConfig[string, int](xmap.Config{TimeSource: testTime})
+	defer cold.Stop()
+
+	hot := xmap.NewWithConfig[string, int](xmap.Config{TimeSource: testTime})
+	defer hot.Stop()
+
+	hot.SetSpillTo(cold, false)
+	cold.SetSpillTo(hot, true) // Mutual spill maps.
+
+	hot.Set("a", 1, time.Minute)
+	hot.Set("b", 2, time.Second) // Expired, dropped.
+	hot.Set("c", 3, time.Hour)
+	hot.Set("d", 4, 0)
+
+	testTime.Advance(2 * time.Second)
+
+	if removed := hot.Trim(2); removed != 2 {
+		t.Fatalf("want %d removed keys, got %d", 2, removed)
+	}
+
+	if cold.Len() != 1 {
+		t.Fatalf("want spill map length %d, got %d", 1, cold.Len())
+	}
+
+	if _, exp, ok := cold.GetWithExpiration("a"); !ok || !exp.Equal(now.Add(time.Minute)) {
+		t.Errorf("want spilled key a with expiration %v, got %v (exists %t)", now.Add(time.Minute), exp, ok)
+	}
+
+	if _, ok := hot.Get("a"); ok {
+		t.Error("want no read fallback when disabled")
+	}
+
+	if value, ok := cold.Get("c"); !ok || value != 3 {
+		t.Errorf("want fallback value %d, got %d (exists %t)", 3, value, ok)
+	}
+
+	if _, ok := cold.Get("missing"); ok {
+		t.Error("want missing key in both maps")
+	}
+}
@@ -0,0 +1,198 @@
+package xmap
+
+import (
+	"slices"
+	"time"
+)
+
+// ExpiryStats represents the expiration statistics of the [Map] keys.
+//
+// Only the keys that have not expired are included.
+type ExpiryStats struct {
+	// NeverExpire is the number of keys that never expire.
+	NeverExpire int
+	// Expiring is the number of keys that have an expiration time.
+	Expiring int
+	// MeanTTL is the mean remaining TTL of the expiring keys.
+	MeanTTL time.Duration
+	// MedianTTL is the median remaining TTL of the expiring keys.
+	MedianTTL time.Duration
+	// Soonest is the earliest expiration time of the expiring keys.
+	Soonest time.Time
+	// Latest is the latest expiration time of the expiring keys.
+	Latest time.Time
+}
+
+// ExpiryStats returns the expiration statistics of the keys in the [Map].
+//
+// The statistics are computed in a single scan while holding the read lock.
+// The median is exact, the remaining TTLs of the expiring keys are collected
+// and sorted, so the cost is O(n log n) with an allocation proportional to the
+// number of expiring keys.
+//
+// The TTL fields are zero if there are no expiring keys.
+func (m *Map[K, V]) ExpiryStats() ExpiryStats {
+	var (
+		stats ExpiryStats
+		ttls  []time.Duration
+		total time.Duration
+	)
+
+	m.mu.RLock()
+	now := m.time.Now()
+	for _, entry := range m.kv.Range {
+		if entry.err != nil || m.expiredAt(entry, now) {
+			continue
+		}
+
+		if entry.exp.IsZero() {
+			stats.NeverExpire++
+			continue
+		}
+
+		if stats.Soonest.IsZero() || entry.exp.Before(stats.Soonest) {
+			stats.Soonest = entry.exp
+		}
+
+		if entry.exp.After(stats.Latest) {
+			stats.Latest = entry.exp
+		}
+
+		ttl := entry.exp.Sub(now)
+		ttls = append(ttls, ttl)
+		total += ttl
+	}
+	m.mu.RUnlock()
+
+	if n := len(ttls); n > 0 {
+		slices.Sort(ttls)
+
+		stats.Expiring = n
+		stats.MeanTTL = total / time.Duration(n)
+
+		if n%2 == 1 {
+			stats.MedianTTL = ttls[n/2]
+		} else {
+			stats.MedianTTL = (ttls[n/2-1] + ttls[n/2]) / 2
+		}
+	}
+
+	return stats
+}
+
+// Stats represents the lookup counters of the [Map].
+type Stats struct {
+	// Hits is the number of lookups of keys that exist in the [Map].
+	Hits uint64
+	// Misses is the number of lookups of keys that are missing or expired.
+	Misses uint64
+}
+
+// Stats returns the lookup counters of the [Map].
+//
+// The lookups are counted by the methods that read a key (e.g. [Map.Get],
+// [Map.GetOrLoad]), the iterations and the scans of the [Map] are not counted.
+func (m *Map[K, V]) Stats() Stats {
+	return Stats{
+		Hits:   m.hits.Load(),
+		Misses: m.misses.Load(),
+	}
+}
+
+// ResetStats sets the lookup counters of the [Map] to zero without changing its
+// entries, and returns the counters values before the reset.
+//
+// Each counter is swapped atomically, so no lookup is lost or counted twice when
+// the counters are sampled and reset periodically (e.g. to compute rates).
+func (m *Map[K, V]) ResetStats() Stats {
+	return Stats{
+		Hits:   m.hits.Swap(0),
+		Misses: m.misses.Swap(0),
+	}
+}
+
+// ExpirationHistogram returns the number of live keys whose remaining TTL falls
+// into each bucket, and the number of live keys that never expire.
+//
+// The buckets are the upper bounds of the remaining TTLs in ascending order, a key
+// is counted in the first bucket that is greater than or equal to its remaining TTL.
+// The returned counts have an extra last element for the keys whose remaining TTL
+// is greater than the last bucket, so len(counts) is len(buckets) + 1.
+//
+// The counts are computed in a single scan while holding the read lock.
+func (m *Map[K, V]) ExpirationHistogram(buckets []time.Duration) (counts []int, neverExpire int) {
+	counts = make([]int, len(buckets)+1)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.time.Now()
+	for _, entry := range m.kv.Range {
+		if entry.err != nil || m.expiredAt(entry, now) {
+			continue
+		}
+
+		if entry.exp.IsZero() {
+			neverExpire++
+			continue
+		}
+
+		i, _ := slices.BinarySearch(buckets, entry.exp.Sub(now))
+		counts[i]++
+	}
+
+	return counts, neverExpire
+}
+
+// LockStats represents the lock contention counters of the [Map].
+type LockStats struct {
+	// WriteLocks is the number of the write lock acquisitions, including the
+	// write locks of the cleanup and of the write methods (e.g. [Map.Set]).
+	WriteLocks uint64
+	// ContendedWriteLocks is the number of the write lock acquisitions that had to
+	// wait because the lock was held, counted when the non-blocking attempt fails.
+	ContendedWriteLocks uint64
+	// WaitTime is the total time spent waiting for the write lock.
+	WaitTime time.Duration
+	// CleanupHoldTime is the total time the write lock was held to remove the
+	// expired keys, by the cleanup goroutine or by [Map.RemoveExpired].
+	CleanupHoldTime time.Duration
+}
+
+// LockStats returns the lock contention counters of the [Map].
+//
+// The counters are only updated if [Config.TrackLockStats] is enabled, otherwise
+// the zero value is returned. They are atomic counters incremented by the write
+// lock (Lock) and by the cleanup before releasing the write lock, the durations
+// are measured with the wall clock regardless of the [Config.TimeSource].
+//
+// A high ratio of contended write locks or a long cleanup hold time indicate that
+// a [Sharded] map might reduce the latency.
+func (m *Map[K, V]) LockStats() LockStats {
+	if m.locks == nil {
+		return LockStats{}
+	}
+
+	return LockStats{
+		WriteLocks:          m.locks.writes.Load(),
+		ContendedWriteLocks: m.locks.contended.Load(),
+		WaitTime:            time.Duration(m.locks.wait.Load()),
+		CleanupHoldTime:     time.Duration(m.locks.cleanup.Load()),
+	}
+}
+
+// lockTime returns the current wall clock time if the lock stats are tracked.
+func (m *Map[K, V]) lockTime() time.Time {
+	if m.locks == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// trackCleanupLock adds the time elapsed since locked to the cleanup hold time
+// if the lock stats are tracked.
+func (m *Map[K, V]) trackCleanupLock(locked time.Time) {
+	if m.locks != nil {
+		m.locks.cleanup.Add(int64(time.Since(locked)))
+	}
+}
@@ -0,0 +1,178 @@
+package xmap_test
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapExpiryStats(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	if got := m.ExpiryStats(); got != (xmap.ExpiryStats{}) {
+		t.Errorf("want zero stats for an empty map, got %+v", got)
+	}
+
+	m.Set("a", 1, 0)
+	m.Set("b", 2, 0)
+	m.Set("c", 3, time.Second) // Expired.
+	m.Set("d", 4, time.Minute)
+	m.Set("e", 5, 2*time.Minute)
+	m.Set("f", 6, 4*time.Minute)
+	m.Set("g", 7, 10*time.Minute)
+
+	testTime.Advance(time.Minute - time.Second)
+
+	got := m.ExpiryStats()
+	want := xmap.ExpiryStats{
+		NeverExpire: 2,
+		Expiring:    4,
+		MeanTTL:     3*time.Minute + 16*time.Second,
+		MedianTTL:   2*time.Minute + time.Second,
+		Soonest:     now.Add(time.Minute),
+		Latest:      now.Add(10 * time.Minute),
+	}
+
+	if got.NeverExpire != want.NeverExpire || got.Expiring != want.Expiring {
+		t.Errorf("want %d never expiring and %d expiring keys, got %d and %d",
+			want.NeverExpire, want.Expiring, got.NeverExpire, got.Expiring)
+	}
+
+	if got.MeanTTL != want.MeanTTL {
+		t.Errorf("want mean TTL %v, got %v", want.MeanTTL, got.MeanTTL)
+	}
+
+	if got.MedianTTL != want.MedianTTL {
+		t.Errorf("want median TTL %v, got %v", want.MedianTTL, got.MedianTTL)
+	}
+
+	if !got.Soonest.Equal(want.Soonest) || !got.Latest.Equal(want.Latest) {
+		t.Errorf("want soonest %v and latest %v, got %v and %v",
+			want.Soonest, want.Latest, got.Soonest, got.Latest)
+	}
+}
+
+func TestMapResetStats(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, int]()
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	m.Get("a")
+	m.Get("a")
+	m.Get("b")
+
+	want := xmap.Stats{Hits: 2, Misses: 1}
+	if got := m.Stats(); got != want {
+		t.Errorf("want stats %+v, got %+v", want, got)
+	}
+
+	if got := m.ResetStats(); got != want {
+		t.Errorf("want stats before reset %+v, got %+v", want, got)
+	}
+
+	if got := m.Stats(); got != (xmap.Stats{}) {
+		t.Errorf("want zero stats after reset, got %+v", got)
+	}
+
+	if m.Len() != 1 {
+		t.Error("want entries kept after reset")
+	}
+}
+
+func TestMapExpirationHistogram(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	testTime := newMockTime(now)
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TimeSource: testTime,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 30*time.Second)
+	m.Set("b", 2, time.Minute) // On the bucket bound.
+	m.Set("c", 3, 5*time.Minute)
+	m.Set("d", 4, 2*time.Hour)
+	m.Set("e", 5, 0)
+	m.Set("f", 6, 0)
+
+	counts, never := m.ExpirationHistogram([]time.Duration{time.Minute, time.Hour})
+
+	if want := []int{2, 1, 1}; !slices.Equal(want, counts) {
+		t.Errorf("want counts %v, got %v", want, counts)
+	}
+
+	if never != 2 {
+		t.Errorf("want %d keys that never expire, got %d", 2, never)
+	}
+}
+
+func TestMapLockStats(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.NewWithConfig[string, int](xmap.Config{
+		TrackLockStats:     true,
+		DisableAutoCleanup: true,
+	})
+	defer m.Stop()
+
+	m.Set("a", 1, 0)
+	m.Set("b", 2, time.Nanosecond)
+
+	var once sync.Once
+	done := make(chan struct{})
+
+	// Hold the read lock until a write is blocked.
+	m.GroupCount(func(string, int) string {
+		once.Do(func() {
+			go func() {
+				defer close(done)
+				m.Set("c", 3, 0)
+			}()
+
+			contended := retryUntil(time.Second, func() bool {
+				return m.LockStats().ContendedWriteLocks == 1
+			})
+			if !contended {
+				t.Error("want a contended write lock")
+			}
+		})
+		return ""
+	})
+
+	<-done
+	m.RemoveExpired()
+
+	stats := m.LockStats()
+	if stats.WriteLocks != 4 || stats.ContendedWriteLocks != 1 {
+		t.Errorf("want %d write locks with %d contended, got %d with %d",
+			4, 1, stats.WriteLocks, stats.ContendedWriteLocks)
+	}
+
+	if stats.WaitTime <= 0 || stats.CleanupHoldTime <= 0 {
+		t.Errorf("want positive wait and cleanup hold times, got %v and %v",
+			stats.WaitTime, stats.CleanupHoldTime)
+	}
+
+	disabled := xmap.New[string, int]()
+	defer disabled.Stop()
+	disabled.Set("a", 1, 0)
+
+	if got := disabled.LockStats(); got != (xmap.LockStats{}) {
+		t.Errorf("want zero lock stats when disabled, got %+v", got)
+	}
+}
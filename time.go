@@ -51,3 +51,32 @@ func (t *systemTicker) C() <-chan time.Time {
 func (t *systemTicker) Stop() {
 	t.ticker.Stop()
 }
+
+var _ Time = (*monotonicTime)(nil)
+
+// monotonicTime is a time source based only on the monotonic clock.
+//
+// The wall clock is read once when the time source is created, the current time is
+// reconstructed by adding the monotonic time elapsed since then to that base, so the
+// returned times are not affected by the wall clock changes, even after stripping
+// their monotonic clock reading, and they drift from the wall clock by the amount
+// of the wall clock adjustments made since the base was read.
+type monotonicTime struct {
+	// The base time read when the time source was created.
+	base time.Time
+}
+
+// newMonotonicTime creates a new monotonic time source.
+func newMonotonicTime() *monotonicTime {
+	return &monotonicTime{time.Now()}
+}
+
+// Now returns the base time plus the monotonic time elapsed since the base time.
+func (t *monotonicTime) Now() time.Time {
+	return t.base.Add(time.Since(t.base))
+}
+
+// NewTicker returns a new system time [Ticker].
+func (t *monotonicTime) NewTicker(d time.Duration) Ticker {
+	return &systemTicker{time.NewTicker(d)}
+}
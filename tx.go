@@ -0,0 +1,80 @@
+package xmap
+
+import "time"
+
+// Tx is a [Map] transaction used to change multiple keys atomically.
+//
+// A Tx is only valid inside the function passed to [Map.Transaction] and
+// must not be used after the function returns.
+type Tx[K comparable, V any] struct {
+	m       *Map[K, V]       // The map the transaction belongs to.
+	changes map[K]*Record[V] // Pending changes, a nil record is a deletion.
+}
+
+// Get returns the value associated with the key.
+//
+// The pending changes made in the transaction are taken into account.
+//
+// The second bool return value reports whether the key exists.
+func (tx *Tx[K, V]) Get(key K) (V, bool) {
+	entry, ok := tx.changes[key]
+	if !ok {
+		entry, ok = tx.m.kv.Get(key)
+	}
+
+	if ok && entry != nil && tx.m.live(entry) {
+		return entry.value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// Set creates or replaces a key-value pair when the transaction is committed.
+//
+// A key can be set to never expire with a ttl value of 0.
+func (tx *Tx[K, V]) Set(key K, value V, ttl time.Duration) {
+	tx.changes[key] = tx.m.newRecord(value, ttl)
+}
+
+// Delete removes a key when the transaction is committed.
+func (tx *Tx[K, V]) Delete(key K) {
+	tx.changes[key] = nil
+}
+
+// Transaction runs the function fn in a transaction to change multiple keys atomically.
+//
+// The changes made using the [Tx] are committed if fn returns a nil error,
+// otherwise the changes are discarded and the error is returned.
+//
+// The [Map] write lock is held for the whole duration of fn, so other goroutines
+// cannot observe a partially applied transaction. As a consequence, fn must only
+// access the [Map] through the [Tx], calling any of the [Map] methods inside fn
+// will cause a deadlock.
+func (m *Map[K, V]) Transaction(fn func(tx *Tx[K, V]) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &Tx[K, V]{
+		m:       m,
+		changes: make(map[K]*Record[V]),
+	}
+
+	if !m.writable() {
+		return ErrFrozen
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	for key, entry := range tx.changes {
+		if entry == nil {
+			m.remove(key)
+		} else {
+			m.store(key, entry)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package xmap_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+func TestMapTransactionCommit(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, int]()
+	defer m.Stop()
+
+	m.Set("a", 1, time.Hour)
+
+	err := m.Transaction(func(tx *xmap.Tx[string, int]) error {
+		value, ok := tx.Get("a")
+		if !ok {
+			t.Fatalf("key %q does not exist in the transaction", "a")
+		}
+
+		// Move the value to a new key.
+		tx.Delete("a")
+		tx.Set("b", value+1, time.Hour)
+
+		// The pending changes are visible in the transaction.
+		if _, ok := tx.Get("a"); ok {
+			t.Errorf("key %q was not deleted in the transaction", "a")
+		}
+
+		if got, ok := tx.Get("b"); !ok || got != 2 {
+			t.Errorf("want transaction value %d for key %q, got %d", 2, "b", got)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected transaction error: %v", err)
+	}
+
+	if _, ok := m.Get("a"); ok {
+		t.Errorf("key %q was not removed from the map", "a")
+	}
+
+	if got, ok := m.Get("b"); !ok || got != 2 {
+		t.Errorf("want value %d for key %q, got %d", 2, "b", got)
+	}
+}
+
+func TestMapTransactionRollback(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, int]()
+	defer m.Stop()
+
+	m.Set("a", 1, time.Hour)
+
+	wantErr := errors.New("rollback")
+
+	err := m.Transaction(func(tx *xmap.Tx[string, int]) error {
+		tx.Delete("a")
+		tx.Set("b", 2, time.Hour)
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("want transaction error %v, got %v", wantErr, err)
+	}
+
+	if got, ok := m.Get("a"); !ok || got != 1 {
+		t.Errorf("want value %d for key %q, got %d", 1, "a", got)
+	}
+
+	if _, ok := m.Get("b"); ok {
+		t.Errorf("key %q must not be created on rollback", "b")
+	}
+}
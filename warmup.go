@@ -0,0 +1,85 @@
+package xmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// WarmUp loads the records read from r into the [Map] and returns the number of
+// the keys that were set.
+//
+// Each record is framed as a 4 bytes big-endian unsigned length followed by a
+// payload of that length, the payload is passed to the decode function that
+// returns the key, the value and the ttl of the record. A ttl value of 0 means
+// that the key never expires, and the records with a negative ttl are considered
+// as expired and skipped. The decode function must not retain the payload slice,
+// it's reused for the next records.
+//
+// The records are set in batches under the write lock as they are decoded,
+// replacing the existing keys. Reading stops at the end of r, at a record boundary
+// it's a successful load, while a partial length or payload returns an error
+// wrapping [io.ErrUnexpectedEOF]. The read and the decode errors are returned
+// along with the number of the keys that were set before the error, these keys
+// are kept in the [Map].
+func (m *Map[K, V]) WarmUp(r io.Reader, decode func([]byte) (K, V, time.Duration, error)) (n int, err error) {
+	var (
+		header  [4]byte
+		payload bytes.Buffer
+		batch   []Entry[K, V]
+		record  int
+	)
+
+	// The batch is flushed on return, including on errors.
+	flush := func() {
+		if len(batch) > 0 {
+			inserted, replaced := m.SetManyR(batch)
+			n += inserted + replaced
+			batch = batch[:0]
+		}
+	}
+	defer flush()
+
+	for ; ; record++ {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return n, nil
+			}
+			return n, fmt.Errorf("xmap: reading record %d length: %w", record, err)
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:]))
+
+		// The payload buffer grows with the data read, so a corrupted length does
+		// not allocate the memory upfront.
+		payload.Reset()
+		if _, err := io.CopyN(&payload, r, size); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, fmt.Errorf("xmap: reading record %d payload: %w", record, err)
+		}
+
+		key, value, ttl, err := decode(payload.Bytes())
+		if err != nil {
+			return n, fmt.Errorf("xmap: decoding record %d: %w", record, err)
+		}
+
+		if ttl < 0 {
+			continue
+		}
+
+		var exp time.Time
+		if ttl > 0 {
+			exp = m.expiration(m.time.Now(), ttl)
+		}
+
+		batch = append(batch, Entry[K, V]{key, value, exp})
+		if len(batch) == setSeqBatchSize {
+			flush()
+		}
+	}
+}
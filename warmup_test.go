@@ -0,0 +1,102 @@
+package xmap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdawar/xmap"
+)
+
+// writeRecord writes a length-prefixed record as expected by [xmap.Map.WarmUp].
+func writeRecord(buf *bytes.Buffer, payload string) {
+	binary.Write(buf, binary.BigEndian, uint32(len(payload)))
+	buf.WriteString(payload)
+}
+
+// decodeRecord decodes a "key=value;ttl" payload.
+func decodeRecord(b []byte) (string, int, time.Duration, error) {
+	pair, ttl, _ := strings.Cut(string(b), ";")
+	key, value, ok := strings.Cut(pair, "=")
+	if !ok {
+		return "", 0, 0, errors.New("invalid record")
+	}
+
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	d, err := time.ParseDuration(ttl)
+	return key, v, d, err
+}
+
+func TestMapWarmUp(t *testing.T) {
+	t.Parallel()
+
+	m := xmap.New[string, int]()
+	defer m.Stop()
+
+	var buf bytes.Buffer
+	for i := range 2000 {
+		writeRecord(&buf, "k"+strconv.Itoa(i)+"="+strconv.Itoa(i)+";1h")
+	}
+	writeRecord(&buf, "never=1;0s")
+	writeRecord(&buf, "expired=1;-1s") // Skipped.
+
+	n, err := m.WarmUp(&buf, decodeRecord)
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+
+	if n != 2001 || m.Len() != 2001 {
+		t.Errorf("want %d loaded keys, got %d (map length %d)", 2001, n, m.Len())
+	}
+
+	if _, exp, ok := m.GetWithExpiration("never"); !ok || !exp.IsZero() {
+		t.Errorf("want key that never expires, got expiration %v (exists %t)", exp, ok)
+	}
+}
+
+func TestMapWarmUpErrors(t *testing.T) {
+	t.Parallel()
+
+	var valid bytes.Buffer
+	writeRecord(&valid, "a=1;0s")
+
+	tests := map[string]struct {
+		tail    []byte
+		wantErr error
+	}{
+		"partial length":  {[]byte{0, 0}, io.ErrUnexpectedEOF},
+		"partial payload": {[]byte{0, 0, 0, 10, 'b'}, io.ErrUnexpectedEOF},
+		"decode error":    {[]byte{0, 0, 0, 1, 'b'}, nil},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			m := xmap.New[string, int]()
+			defer m.Stop()
+
+			r := io.MultiReader(bytes.NewReader(valid.Bytes()), bytes.NewReader(tt.tail))
+
+			n, err := m.WarmUp(r, decodeRecord)
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+			}
+
+			// The records before the error are kept.
+			if want := map[string]int{"a": 1}; n != 1 || !maps.Equal(want, maps.Collect(m.All())) {
+				t.Errorf("want %v (%d loaded), got %v (%d loaded)", want, 1, maps.Collect(m.All()), n)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package xmap
+
+// OnKeyExpire returns a channel that is closed when the key is removed from the
+// [Map], either when it expires or when it's deleted.
+//
+// The expired keys are removed by the cleanup goroutine or by [Map.RemoveExpired],
+// so the channel is closed when the expired key is removed, not at its exact
+// expiration time. The channels are also closed when the [Map] is cleared or stopped.
+//
+// The watchers follow the key not its value, replacing the key before it expires
+// (e.g. with a new ttl) does not close the channel, the channel is closed when the
+// new value expires or it's deleted. Multiple watchers of the same key are supported.
+//
+// A closed channel is returned if the key does not exist or it has already expired.
+func (m *Map[K, V]) OnKeyExpire(key K) <-chan struct{} {
+	ch := make(chan struct{})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry, ok := m.kv.Get(key); !ok || !m.live(entry) || m.Stopped() {
+		close(ch)
+		return ch
+	}
+
+	if m.watchers == nil {
+		m.watchers = make(map[K][]chan struct{})
+	}
+	m.watchers[key] = append(m.watchers[key], ch)
+
+	return ch
+}
+
+// notify closes the watchers channels of the key.
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) notify(key K) {
+	for _, ch := range m.watchers[key] {
+		close(ch)
+	}
+	delete(m.watchers, key)
+}
+
+// notifyAll closes the watchers channels of all the keys.
+//
+// The write lock must be held when calling this method.
+func (m *Map[K, V]) notifyAll() {
+	for key := range m.watchers {
+		m.notify(key)
+	}
+}
@@ -0,0 +1,63 @@
+// Package xmaptest provides utilities for testing code that uses [xmap.Map].
+package xmaptest
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+
+	"github.com/mdawar/xmap"
+)
+
+// Map is the subset of the [xmap.Map] methods used by the test helpers.
+type Map interface {
+	Stop()
+	Stopped() bool
+	CleanupActive() bool
+}
+
+var _ Map = (*xmap.Map[string, int])(nil)
+
+// AssertClean stops the map m and verifies that the cleanup goroutine has exited
+// and that no goroutines were leaked.
+//
+// The leak detection is done using [goleak.Find] which reports all the leaked
+// goroutines, not only the ones started by the map. The opts are passed to
+// [goleak.Find], for example [goleak.IgnoreCurrent] can be used to ignore the
+// goroutines that were running before the map was created.
+func AssertClean(t testing.TB, m Map, opts ...goleak.Option) {
+	t.Helper()
+
+	m.Stop()
+
+	if !m.Stopped() {
+		t.Error("xmaptest: map was not stopped")
+	}
+
+	// Retries until the goroutines exit or the default timeout is reached.
+	if err := goleak.Find(opts...); err != nil {
+		t.Errorf("xmaptest: goroutines leaked after stopping the map: %v", err)
+	}
+
+	if m.CleanupActive() {
+		t.Error("xmaptest: cleanup goroutine is still active after stopping the map")
+	}
+}
+
+// Fill sets n keys in the map m, the key and the value of the i-th key are returned
+// by keyGen(i) and valGen(i) for i in [0, n), and all the keys are set with the
+// same ttl (0 means that the keys never expire).
+//
+// The keys are set using [xmap.Map.SetSeq] in batches under the write lock, so the
+// generated keys replace the existing keys and the duplicate keys are set once.
+// It's intended to be called before the timer of a benchmark is started or reset.
+func Fill[K comparable, V any](m *xmap.Map[K, V], n int, keyGen func(i int) K, valGen func(i int) V, ttl time.Duration) {
+	m.SetSeq(func(yield func(K, V) bool) {
+		for i := range n {
+			if !yield(keyGen(i), valGen(i)) {
+				return
+			}
+		}
+	}, ttl)
+}
@@ -0,0 +1,45 @@
+package xmaptest_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+
+	"github.com/mdawar/xmap"
+	"github.com/mdawar/xmap/xmaptest"
+)
+
+func TestAssertClean(t *testing.T) {
+	ignore := goleak.IgnoreCurrent()
+
+	m := xmap.New[string, int]()
+	m.Set("a", 1, time.Hour)
+
+	xmaptest.AssertClean(t, m, ignore)
+
+	if m.Len() != 0 {
+		t.Errorf("want map length %d, got %d", 0, m.Len())
+	}
+}
+
+func TestFill(t *testing.T) {
+	m := xmap.New[string, int]()
+	defer m.Stop()
+
+	key := func(i int) string { return strconv.Itoa(i) }
+	value := func(i int) int { return i * 10 }
+
+	xmaptest.Fill(m, 2000, key, value, time.Hour)
+
+	if m.Len() != 2000 {
+		t.Fatalf("want map length %d, got %d", 2000, m.Len())
+	}
+
+	for _, i := range []int{0, 1023, 1024, 1999} {
+		if got, ok := m.Get(key(i)); !ok || got != value(i) {
+			t.Errorf("want key %q value %d, got %d (exists %t)", key(i), value(i), got, ok)
+		}
+	}
+}